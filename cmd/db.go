@@ -0,0 +1,57 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/config"
+	"github.com/jad0s/libretalk/internal/db"
+
+	"golang.org/x/term"
+)
+
+// openDB connects to the configured database. The password is prompted
+// for only when nothing non-interactive is configured and stdin is a terminal.
+func openDB(cfg *config.Config) (*sql.DB, error) {
+	if !cfg.Database.HasCredentials() {
+		// only fall back to prompting when someone is there to answer
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, fmt.Errorf("no database password configured: set LIBRETALK_DB_PASSWORD, LIBRETALK_DB_PASSWORD_FILE " +
+				"or LIBRETALK_DB_DSN, or provide a db_password systemd credential")
+		}
+		fmt.Fprint(os.Stderr, "Enter DB password: ")
+		//reads password without showing it in terminal
+		pw, err := term.ReadPassword(int(os.Stdin.Fd()))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintln(os.Stderr)
+		cfg.Database.Password = strings.TrimSpace(string(pw))
+	}
+	database, err := db.Connect(cfg.Database.Driver, cfg.Database.ConnString())
+	if err != nil {
+		return nil, fmt.Errorf("DB connection error: %w", err)
+	}
+	return database, nil
+}
+
+// openStore opens the storage backend selected by database.driver.
+func openStore(cfg *config.Config) (store.Store, error) {
+	if cfg.Database.Driver == "memory" {
+		return store.NewMemory(), nil
+	}
+	database, err := openDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Database.Driver {
+	case "postgres":
+		return store.NewPostgres(database), nil
+	case "sqlite":
+		return store.NewSQLite(database), nil
+	}
+	return store.NewMySQL(database), nil
+}
@@ -1,46 +1,82 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
-	"strings"
 
-	"github.com/jad0s/libretalk/internal/chat"
-	"github.com/jad0s/libretalk/internal/db"
-
-	"golang.org/x/term"
+	"github.com/jad0s/libretalk/internal/auth"
+	"github.com/jad0s/libretalk/internal/config"
+	"github.com/jad0s/libretalk/internal/logging"
 )
 
+const usage = `usage: libretalk [-config file] <command> [arguments]
+
+commands:
+  serve                          run the chat server (default)
+  migrate up                     apply all pending schema migrations
+  migrate down [n]               roll back the last n migrations (default 1)
+  migrate status                 list migrations and whether they are applied
+  user add <name>                create a user, password is read from stdin
+  user passwd <name>             change a user's password
+  user delete <name>             remove a user
+  user list                      list all users
+  token issue [-ttl d] <name>    print a JWT for an existing user
+  token inspect <token>          validate a JWT and print its claims
+`
+
 func main() {
-	fmt.Print("Enter DB password: ")
-	//reads password without showing it in terminal
-	pw, err := term.ReadPassword(int(os.Stdin.Fd()))
-	if err != nil {
-		log.Fatal(err)
+	flags := flag.NewFlagSet("libretalk", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage, "\nflags:\n")
+		flags.PrintDefaults()
 	}
-	fmt.Println()
-	//database connection --TODO - change db IP and PORT to silent input like db password for prod
-	dsn := fmt.Sprintf("server:%s@tcp(chatdb.s:3306)/selfchat",
-		strings.TrimSpace(string(pw)),
-	)
-	database, err := db.Connect(dsn)
-	if err != nil {
-		log.Fatal("DB connection error:", err)
+	configPath := flags.String("config", os.Getenv("LIBRETALK_CONFIG"), "path to a .toml, .json or .yaml config file")
+	flags.Parse(os.Args[1:])
+
+	cmd, args := "serve", flags.Args()
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
 	}
-	defer database.Close()
-	log.Println("DB connected")
 
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		chat.Handler(w, r, database)
-	})
+	// only the commands that sign or check tokens need auth.jwtSecret
+	var run func(*config.Config, []string) error
+	var needsSecret bool
+	switch cmd {
+	case "serve":
+		run, needsSecret = runServe, true
+	case "migrate":
+		run = runMigrate
+	case "user":
+		run = runUser
+	case "token":
+		run, needsSecret = runToken, true
+	case "help":
+		flags.Usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		flags.Usage()
+		os.Exit(2)
+	}
 
-	http.HandleFunc("/upload", chat.UploadHandler(database))
-	fs := http.FileServer(http.Dir("./uploads"))
-	http.Handle("/uploads/", http.StripPrefix("/uploads/", fs))
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	logging.Setup(cfg.Log)
+	if needsSecret {
+		if err := auth.Configure(cfg.Auth); err != nil {
+			fatal(err)
+		}
+	}
+	if err := run(cfg, args); err != nil {
+		fatal(err)
+	}
+}
 
-	addr := ":8081"
-	log.Println("Listening on", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
 }
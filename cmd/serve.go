@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/jad0s/libretalk/internal/chat"
+	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/config"
+	"github.com/jad0s/libretalk/internal/health"
+	"github.com/jad0s/libretalk/internal/listen"
+	"github.com/jad0s/libretalk/internal/metrics"
+	"github.com/jad0s/libretalk/internal/migrate"
+	"github.com/jad0s/libretalk/internal/proxy"
+	"github.com/jad0s/libretalk/internal/retention"
+	"github.com/jad0s/libretalk/internal/tlscert"
+)
+
+// runServe starts the chat server and blocks until SIGINT/SIGTERM.
+func runServe(cfg *config.Config, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("serve takes no arguments")
+	}
+	st, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	if s, ok := st.(*store.SQL); ok {
+		slog.Info("DB connected", "driver", s.Driver())
+		if err := prepareSchema(cfg, s.DB()); err != nil {
+			return err
+		}
+	} else {
+		slog.Warn("using the in-memory store, nothing is kept across restarts")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		chat.Handler(w, r, st, cfg)
+	})
+
+	mux.HandleFunc("/upload", chat.UploadHandler(st, cfg))
+	mux.HandleFunc("/search", chat.SearchHandler(st))
+	fs := http.FileServer(http.Dir(cfg.UploadDir))
+	mux.Handle("/uploads/", http.StripPrefix("/uploads/", fs))
+
+	mux.HandleFunc("/healthz", health.LiveHandler())
+	mux.HandleFunc("/readyz", health.ReadyHandler(st, cfg.UploadDir, chat.Draining))
+	mux.HandleFunc("/metrics", metrics.Handler())
+
+	proxies, err := proxy.NewResolver(cfg.Proxy.Trusted)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: proxies.Middleware(mux)}
+
+	var certs *tlscert.Reloader
+	if cfg.TLS.Enabled() {
+		certs, err = tlscert.New(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return fmt.Errorf("TLS: %w", err)
+		}
+	}
+
+	mode, err := cfg.SocketFileMode()
+	if err != nil {
+		return err
+	}
+	ln, err := listen.Listen(cfg.Listen, mode)
+	if err != nil {
+		return err
+	}
+	if cfg.Proxy.Protocol {
+		ln = proxies.Listen(ln)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// background jobs must be done with the store before it is closed below
+	var jobs sync.WaitGroup
+	expirer := retention.NewExpirer(st, cfg.Retention.BatchSize, cfg.UploadDir, chat.NotifyExpired)
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		expirer.Run(ctx, cfg.Messages.ExpiryInterval.Duration)
+	}()
+	if cfg.Retention.Enabled() {
+		job := retention.New(st, cfg.Retention, cfg.UploadDir)
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			job.Run(ctx, cfg.Retention.Interval.Duration)
+		}()
+	}
+
+	errc := make(chan error, 1)
+	if certs != nil {
+		go certs.Watch(ctx, cfg.TLS.WatchInterval.Duration)
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+		go func() {
+			slog.Info("listening", "addr", ln.Addr().String(), "tls", true)
+			errc <- srv.ServeTLS(ln, "", "")
+		}()
+	} else {
+		go func() {
+			slog.Info("listening", "addr", ln.Addr().String(), "tls", false)
+			errc <- srv.Serve(ln)
+		}()
+	}
+
+	// a failed server goes down the same way as a signalled one, so the
+	// jobs and live connections are done before the store is closed
+	var serveErr error
+	select {
+	case serveErr = <-errc:
+		slog.Error("server error, shutting down", "err", serveErr)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process the usual way; also stops the jobs
+	slog.Info("shutting down, draining connections")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+	// WebSockets are hijacked, so http.Server.Shutdown doesn't see them;
+	// drain those first, then let the server finish plain HTTP requests.
+	if err := chat.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("websocket drain", "err", err)
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("http shutdown", "err", err)
+	}
+	jobs.Wait()
+	// only now that no handler is left can the DB go away
+	if err := st.Close(); err != nil {
+		slog.Warn("DB close", "err", err)
+	}
+	slog.Info("shutdown complete")
+	if serveErr != nil {
+		return fmt.Errorf("server error: %w", serveErr)
+	}
+	return nil
+}
+
+// prepareSchema applies pending migrations, or with auto_migrate off
+// refuses to start on an outdated schema.
+func prepareSchema(cfg *config.Config, database *sql.DB) error {
+	if cfg.Database.AutoMigrate {
+		ran, err := migrate.Up(database, cfg.Database.Driver)
+		for _, m := range ran {
+			slog.Info("applied migration", "version", m.Version, "name", m.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("migrate: %w", err)
+		}
+		return nil
+	}
+	pending, err := migrate.Pending(database, cfg.Database.Driver)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is %d migration(s) behind, run `libretalk migrate up` "+
+			"or enable database.auto_migrate", len(pending))
+	}
+	return nil
+}
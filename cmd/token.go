@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/auth"
+	"github.com/jad0s/libretalk/internal/config"
+)
+
+func runToken(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: libretalk token issue [-ttl d] <name> | inspect <token>")
+	}
+	switch args[0] {
+	case "issue":
+		fs := flag.NewFlagSet("token issue", flag.ContinueOnError)
+		ttl := fs.Duration("ttl", cfg.Auth.TokenTTL.Duration, "token lifetime")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: libretalk token issue [-ttl d] <name>")
+		}
+		user := fs.Arg(0)
+
+		st, err := openStore(cfg)
+		if err != nil {
+			return err
+		}
+		defer st.Close()
+		ok, err := auth.Exists(st, user)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%q: %w", user, auth.ErrUserNotFound)
+		}
+
+		// Configure again so GenerateToken picks up the -ttl override.
+		a := cfg.Auth
+		a.TokenTTL = config.Duration{Duration: *ttl}
+		if err := auth.Configure(a); err != nil {
+			return err
+		}
+		token, err := auth.GenerateToken(user)
+		if err != nil {
+			return err
+		}
+		fmt.Println(token)
+
+	case "inspect":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: libretalk token inspect <token>")
+		}
+		c, err := auth.ParseClaims(args[1])
+		if err != nil {
+			return fmt.Errorf("token is not valid: %w", err)
+		}
+		fmt.Println("username:", c.Username)
+		if !c.ExpiresAt.IsZero() {
+			fmt.Printf("expires:  %s (in %s)\n",
+				c.ExpiresAt.Format(time.RFC3339), time.Until(c.ExpiresAt).Round(time.Second))
+		}
+
+	default:
+		return fmt.Errorf("unknown token command %q", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jad0s/libretalk/internal/auth"
+	"github.com/jad0s/libretalk/internal/config"
+
+	"golang.org/x/term"
+)
+
+func runUser(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: libretalk user add|passwd|delete <name> | list")
+	}
+	verb, args := args[0], args[1:]
+	if verb == "list" {
+		if len(args) != 0 {
+			return fmt.Errorf("usage: libretalk user list")
+		}
+	} else if len(args) != 1 || args[0] == "" {
+		return fmt.Errorf("usage: libretalk user %s <name>", verb)
+	}
+
+	st, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	switch verb {
+	case "add":
+		pw, err := readNewPassword()
+		if err != nil {
+			return err
+		}
+		if err := auth.Register(st, args[0], pw); err != nil {
+			return err
+		}
+		fmt.Printf("user %q created\n", args[0])
+
+	case "passwd":
+		pw, err := readNewPassword()
+		if err != nil {
+			return err
+		}
+		if err := auth.SetPassword(st, args[0], pw); err != nil {
+			return fmt.Errorf("%q: %w", args[0], err)
+		}
+		fmt.Printf("password for %q changed\n", args[0])
+
+	case "delete":
+		if err := auth.Delete(st, args[0]); err != nil {
+			return fmt.Errorf("%q: %w", args[0], err)
+		}
+		fmt.Printf("user %q deleted\n", args[0])
+
+	case "list":
+		users, err := auth.List(st)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			fmt.Println(u)
+		}
+
+	default:
+		return fmt.Errorf("unknown user command %q", verb)
+	}
+	return nil
+}
+
+// readNewPassword asks twice on a terminal, or reads the first line of
+// stdin when it is piped (`echo secret | libretalk user add alice`).
+func readNewPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("read password from stdin: %w", err)
+		}
+		pw := strings.TrimRight(line, "\r\n")
+		if pw == "" {
+			return "", errors.New("password must not be empty")
+		}
+		return pw, nil
+	}
+
+	fmt.Fprint(os.Stderr, "New password: ")
+	pw, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Repeat password: ")
+	again, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(pw) != string(again) {
+		return "", errors.New("passwords do not match")
+	}
+	if len(pw) == 0 {
+		return "", errors.New("password must not be empty")
+	}
+	return string(pw), nil
+}
@@ -1,40 +1,62 @@
 package auth
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+
+	"github.com/jad0s/libretalk/internal/chat/store"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrUserNotFound is returned when no user has the given name.
+var ErrUserNotFound = store.ErrUserNotFound
+
 // Register creates a new user record with a bcrypt-hashed password.
-func Register(db *sql.DB, username, password string) error {
+func Register(users store.UserStore, username, password string) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return fmt.Errorf("hash password: %w", err)
 	}
-	query := "INSERT INTO users (username, password_hash) VALUES (?, ?)"
-	if _, err := db.Exec(query, username, string(hash)); err != nil {
-		return fmt.Errorf("insert user: %w", err)
-	}
-	return nil
+	return users.CreateUser(username, string(hash))
 }
 
-// Login verifies a username/password against the DB.
-func Login(db *sql.DB, username, password string) (string, error) {
-	var hash string
-	query := "SELECT password_hash FROM users WHERE username = ?"
-	if err := db.QueryRow(query, username).Scan(&hash); err != nil {
-		if err == sql.ErrNoRows {
-			return "", fmt.Errorf("user not found")
-		}
-		return "", fmt.Errorf("query user: %w", err)
+// Login verifies a username/password against the user store.
+func Login(users store.UserStore, username, password string) (string, error) {
+	hash, err := users.PasswordHash(username)
+	if err != nil {
+		return "", err
 	}
 	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
 		return "", fmt.Errorf("invalid password")
 	}
-	log.Printf("user %q logged in", username)
-
 	return GenerateToken(username)
 }
+
+// SetPassword replaces a user's password hash.
+func SetPassword(users store.UserStore, username, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	return users.SetPasswordHash(username, string(hash))
+}
+
+// Delete removes a user record. Their messages are left in place.
+func Delete(users store.UserStore, username string) error {
+	return users.DeleteUser(username)
+}
+
+// Exists reports whether a user with this name is registered.
+func Exists(users store.UserStore, username string) (bool, error) {
+	_, err := users.PasswordHash(username)
+	if errors.Is(err, store.ErrUserNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns every username in alphabetical order.
+func List(users store.UserStore) ([]string, error) {
+	return users.ListUsers()
+}
@@ -4,41 +4,81 @@ import (
 	"fmt"
 	"time"
 
-	"encoding/base64"
+	"github.com/jad0s/libretalk/internal/config"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret, _ = base64.StdEncoding.DecodeString("12SNtOQ9LS3nSAHAZl0EQaciRDCsLDCFCEzeIJvx5ss=") // TODO load from .env
+var (
+	jwtSecret []byte
+	tokenTTL  = 24 * time.Hour
+)
+
+// Configure installs the JWT signing key and token lifetime.
+// It must be called before any token is generated or parsed.
+func Configure(c config.Auth) error {
+	key, err := c.Secret()
+	if err != nil {
+		return fmt.Errorf("jwt secret: %w", err)
+	}
+	jwtSecret = key
+	tokenTTL = c.TokenTTL.Duration
+	return nil
+}
 
-// GenerateToken returns a JWT signed with HS256, expiring in 24h.
+// GenerateToken returns a JWT signed with HS256, expiring after the configured TTL.
 func GenerateToken(username string) (string, error) {
+	if jwtSecret == nil {
+		return "", fmt.Errorf("auth not configured")
+	}
 	claims := jwt.MapClaims{
 		"username": username,
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
+		"exp":      time.Now().Add(tokenTTL).Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(jwtSecret)
 }
 
+// Claims is what a valid token says about its holder.
+type Claims struct {
+	Username  string
+	ExpiresAt time.Time
+}
+
 // ParseToken validates the token string and returns the username.
 func ParseToken(tokenStr string) (string, error) {
+	c, err := ParseClaims(tokenStr)
+	if err != nil {
+		return "", err
+	}
+	return c.Username, nil
+}
+
+// ParseClaims validates the token string and returns its claims.
+func ParseClaims(tokenStr string) (Claims, error) {
 	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 		}
+		if jwtSecret == nil {
+			return nil, fmt.Errorf("auth not configured")
+		}
 		return jwtSecret, nil
 	})
 	if err != nil {
-		return "", err
+		return Claims{}, err
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok || !token.Valid {
-		return "", fmt.Errorf("invalid token")
+		return Claims{}, fmt.Errorf("invalid token")
 	}
 	user, ok := claims["username"].(string)
 	if !ok {
-		return "", fmt.Errorf("username claim missing")
+		return Claims{}, fmt.Errorf("username claim missing")
+	}
+	c := Claims{Username: user}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		c.ExpiresAt = exp.Time
 	}
-	return user, nil
+	return c, nil
 }
@@ -0,0 +1,55 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/jad0s/libretalk/internal/auth"
+	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxAckIDs bounds one "ack" frame; clients ack in chunks.
+const maxAckIDs = 500
+
+// handleAck answers an "ack" frame: the listed messages sent to the caller
+// reached one of their devices and need not be replayed any more. When the
+// socket logged in with a device ID, they are recorded for that device
+// too, whose sync cursor moves up as far as it has acked without a gap.
+// A logged-in socket may only ack for the user it logged in as.
+func handleAck(conn *websocket.Conn, messages store.MessageStore, loggedIn, device string, rawMsg []byte, flog *slog.Logger) {
+	var req types.AckRequest
+	if err := json.Unmarshal(rawMsg, &req); err != nil {
+		sendError(conn, "ack", "bad ack")
+		return
+	}
+	flog.Debug("frame received", "req", req)
+	user, err := auth.ParseToken(req.Token)
+	if err != nil {
+		sendError(conn, "ack", "invalid token")
+		return
+	}
+	if loggedIn != "" && user != loggedIn {
+		sendError(conn, "ack", "token is not for the logged-in user")
+		return
+	}
+	if len(req.IDs) == 0 || len(req.IDs) > maxAckIDs {
+		sendError(conn, "ack", fmt.Sprintf("ids must list 1 to %d message ids", maxAckIDs))
+		return
+	}
+	if err := messages.MarkDelivered(user, req.IDs); err != nil {
+		flog.Error("mark delivered", "ids", len(req.IDs), "err", err)
+		sendError(conn, "ack", "internal error")
+		return
+	}
+	if device == "" {
+		return
+	}
+	if err := messages.MarkSynced(user, device, req.IDs); err != nil {
+		flog.Error("mark synced", "device", device, "err", err)
+		sendError(conn, "ack", "internal error")
+	}
+}
@@ -0,0 +1,36 @@
+package chat
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jad0s/libretalk/internal/auth"
+)
+
+// bearerUser authenticates an HTTP request by its "Authorization: Bearer
+// <JWT>" header. On failure it has already answered 401 and returns false.
+func bearerUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "missing authorization", http.StatusUnauthorized)
+		return "", false
+	}
+
+	if !strings.HasPrefix(strings.ToLower(authHeader), "bearer ") {
+		http.Error(w, "bad authorization scheme", http.StatusUnauthorized)
+		return "", false
+	}
+
+	parts := strings.Fields(authHeader)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		http.Error(w, "bad authorization header", http.StatusUnauthorized)
+		return "", false
+	}
+
+	username, err := auth.ParseToken(parts[1])
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return "", false
+	}
+	return username, true
+}
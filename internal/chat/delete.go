@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/auth"
+	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// handleDelete answers a "delete" frame. Scope "me" hides the message from
+// the caller's history and tells only the caller's connections; scope
+// "everyone" leaves a tombstone and tells both participants. window is how
+// long after sending the latter is allowed, zero for no limit. An upload
+// that only the deleted message linked to is removed from uploadDir.
+func handleDelete(conn *websocket.Conn, messages store.MessageStore, window time.Duration, uploadDir string, rawMsg []byte, flog *slog.Logger) {
+	var req types.DeleteRequest
+	if err := json.Unmarshal(rawMsg, &req); err != nil {
+		sendError(conn, "delete", "bad delete request")
+		return
+	}
+	flog.Debug("frame received", "req", req)
+	user, err := auth.ParseToken(req.Token)
+	if err != nil {
+		sendError(conn, "delete", "invalid token")
+		return
+	}
+
+	var m types.MessageRow
+	var upload string
+	switch req.Scope {
+	case "me":
+		m, err = messages.HideMessage(req.ID, user)
+	case "everyone":
+		m, upload, err = messages.DeleteMessage(req.ID, user, window)
+	default:
+		sendError(conn, "delete", `scope must be "me" or "everyone"`)
+		return
+	}
+	switch {
+	case errors.Is(err, store.ErrMessageNotFound),
+		errors.Is(err, store.ErrNotSender),
+		errors.Is(err, store.ErrMessageDeleted),
+		errors.Is(err, store.ErrDeleteExpired):
+		sendError(conn, "delete", err.Error())
+		return
+	case err != nil:
+		flog.Error("delete message", "id", req.ID, "scope", req.Scope, "err", err)
+		sendError(conn, "delete", "internal error")
+		return
+	}
+
+	ev := types.MessageDeleted{
+		Type:  "deleted",
+		ID:    m.ID,
+		Scope: req.Scope,
+		From:  m.Sender,
+		To:    m.Recipient,
+	}
+	if req.Scope == "me" {
+		ev.DeletedAt = time.Now().UTC()
+		notifyParticipants(user, user, ev)
+		return
+	}
+	if upload != "" {
+		err := os.Remove(filepath.Join(uploadDir, upload))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			flog.Warn("remove upload", "file", upload, "err", err)
+		}
+	}
+	ev.DeletedAt = *m.DeletedAt
+	notifyParticipants(m.Sender, m.Recipient, ev)
+}
@@ -0,0 +1,68 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/jad0s/libretalk/internal/auth"
+	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// handleEdit answers an "edit" frame: the sender replaces a message's text
+// and every live connection of both participants is told.
+func handleEdit(conn *websocket.Conn, messages store.MessageStore, rawMsg []byte, flog *slog.Logger) {
+	var req types.EditRequest
+	if err := json.Unmarshal(rawMsg, &req); err != nil {
+		sendError(conn, "edit", "bad edit request")
+		return
+	}
+	flog.Debug("frame received", "req", req)
+	user, err := auth.ParseToken(req.Token)
+	if err != nil {
+		sendError(conn, "edit", "invalid token")
+		return
+	}
+	if req.Content == "" {
+		sendError(conn, "edit", "content must not be empty")
+		return
+	}
+
+	m, err := messages.EditMessage(req.ID, user, req.Content)
+	switch {
+	case errors.Is(err, store.ErrMessageNotFound),
+		errors.Is(err, store.ErrNotSender),
+		errors.Is(err, store.ErrNotEditable),
+		errors.Is(err, store.ErrMessageDeleted):
+		sendError(conn, "edit", err.Error())
+		return
+	case err != nil:
+		flog.Error("edit message", "id", req.ID, "err", err)
+		sendError(conn, "edit", "internal error")
+		return
+	}
+
+	notifyParticipants(m.Sender, m.Recipient, types.MessageEdited{
+		Type:     "edited",
+		ID:       m.ID,
+		From:     m.Sender,
+		To:       m.Recipient,
+		Content:  m.Content,
+		EditedAt: *m.EditedAt,
+	})
+}
+
+// notifyParticipants sends v to every live connection of both sides of a
+// conversation, the acting user's own included.
+func notifyParticipants(a, b string, v interface{}) {
+	targets := connectionsFor(a)
+	if b != a {
+		targets = append(targets, connectionsFor(b)...)
+	}
+	for _, ci := range targets {
+		send(ci.Conn, v)
+	}
+}
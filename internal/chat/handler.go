@@ -1,83 +1,115 @@
 package chat
 
 import (
-	"database/sql"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/jad0s/libretalk/internal/auth"
 	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/config"
+	"github.com/jad0s/libretalk/internal/proxy"
 	"github.com/jad0s/libretalk/internal/types"
 
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+// originChecker accepts any origin when allowed is empty, otherwise only
+// the listed ones (compared case-insensitively, e.g. "https://chat.example.org").
+func originChecker(allowed []string) func(r *http.Request) bool {
+	if len(allowed) == 0 {
+		return func(r *http.Request) bool { return true }
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		for _, a := range allowed {
+			if strings.EqualFold(origin, a) {
+				return true
+			}
+		}
+		return false
+	}
 }
 
+// maxClientMsgID is the longest clientMsgId messages.client_msg_id holds.
+const maxClientMsgID = 64
+
+// connSeq numbers sockets so every log line can be tied to one connection.
+var connSeq atomic.Uint64
+
 // Handler is the WebSocket entrypoint for chat.
-func Handler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func Handler(w http.ResponseWriter, r *http.Request, st store.Store, cfg *config.Config) {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     originChecker(cfg.AllowedOrigins),
+	}
+	if Draining() {
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("upgrade:", err)
+		slog.Warn("websocket upgrade failed", "ip", proxy.ClientIP(r), "err", err)
 		http.Error(w, "upgrade failed", http.StatusBadRequest)
 		return
 	}
+	if !trackSocket(conn) {
+		// Shutdown started between the check above and the upgrade
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+			time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
+
+	clientIP := proxy.ClientIP(r)
+	clog := slog.With("conn", fmt.Sprintf("c%d", connSeq.Add(1)), "ip", clientIP)
+	clog.Debug("connected")
+
+	// loggedIn and device are the user and device ID this socket logged
+	// in with, if any
+	var loggedIn, device string
 
-	// 1) Cleanup on disconnect
+	// 1) Cleanup on disconnect; the socket's writer sends the pings
 	defer func() {
 		conn.Close()
-		for user, list := range connections {
-			for i, ci := range list {
-				if ci.Conn == conn {
-					connections[user] = append(list[:i], list[i+1:]...)
-					break
-				}
-			}
-		}
-	}()
-
-	go func() {
-		ticker := time.NewTicker(10 * time.Second)
-		defer ticker.Stop()
-		for range ticker.C {
-			if err := conn.WriteJSON(map[string]string{"type": "ping"}); err != nil {
-				log.Println("json-ping failed, closing:", err)
-				conn.Close()
-				return
-			}
-		}
+		untrackSocket(conn)
 	}()
 
-	// 3) Start ping loop in a separate goroutine
-
-	// 4) Main read loop
+	// 2) Main read loop
 	for {
 		_, rawMsg, err := conn.ReadMessage()
 		if err != nil {
-			log.Println("read error:", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				clog.Warn("read error", "err", err)
+			} else {
+				clog.Debug("disconnected", "err", err)
+			}
 			break
 		}
 
 		// Peek at "type"
 		var peek map[string]interface{}
 		if err := json.Unmarshal(rawMsg, &peek); err != nil {
-			conn.WriteJSON(map[string]string{"type": "error", "msg": "invalid JSON"})
+			framesHandled.Inc(frameLabel(""))
+			sendError(conn, "", "invalid JSON")
 			continue
 		}
 		t, _ := peek["type"].(string)
+		framesHandled.Inc(frameLabel(t))
+		flog := clog.With("frame", t)
 
 		switch t {
 
 		// ─── AUTH ACTIONS ─────────────────────────────────────────────────────────
 		case "ping":
 			// client is checking server; echo back
-			conn.WriteJSON(map[string]string{"type": "pong"})
+			reply(conn, map[string]string{"type": "pong"})
 			continue
 		case "pong":
 			// client responded to our ping; could update lastSeen here
@@ -89,150 +121,227 @@ func Handler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		case "action":
 			var req types.ActionRequest
 			if err := json.Unmarshal(rawMsg, &req); err != nil {
-				conn.WriteJSON(map[string]string{"type": "error", "msg": "bad action"})
+				sendError(conn, "action", "bad action")
 				continue
 			}
+			flog.Debug("frame received", "req", req)
 			switch req.Action {
 			case "register":
-				if err := auth.Register(db, req.Username, req.Password); err != nil {
-					conn.WriteJSON(map[string]string{"type": "error", "msg": err.Error()})
+				if err := auth.Register(st, req.Username, req.Password); err != nil {
+					flog.Warn("register failed", "username", req.Username, "err", err)
+					sendError(conn, "action", err.Error())
 				} else {
-					conn.WriteJSON(map[string]string{"type": "register", "status": "ok"})
+					flog.Info("user registered", "username", req.Username)
+					reply(conn, map[string]string{"type": "register", "status": "ok"})
 				}
 
 			case "login":
+				if len(req.DeviceID) > maxDeviceID {
+					sendError(conn, "action", fmt.Sprintf("deviceId must be at most %d characters", maxDeviceID))
+					continue
+				}
 				// 1) authenticate + get token
-				token, err := auth.Login(db, req.Username, req.Password)
+				token, err := auth.Login(st, req.Username, req.Password)
 				if err != nil {
-					conn.WriteJSON(map[string]string{"type": "error", "msg": err.Error()})
+					flog.Warn("login failed", "username", req.Username, "err", err)
+					sendError(conn, "action", err.Error())
 					continue
 				}
+				clog = clog.With("user", req.Username)
+				clog.Info("logged in")
 				// 2) send token back
-				conn.WriteJSON(map[string]string{
+				reply(conn, map[string]string{
 					"type":   "login",
 					"status": "ok",
 					"token":  token,
 				})
-				// 3) register this connection
+				// 3) register this connection and replay what it missed
+				loggedIn, device = req.Username, req.DeviceID
 				ci := types.ConnectionInfo{
 					Conn:        conn,
-					IP:          conn.RemoteAddr().String(),
+					IP:          clientIP,
 					ConnectedAt: time.Now(),
+					Device:      device,
 				}
-				connections[req.Username] = append(connections[req.Username], ci)
-				// 4) replay undelivered messages
-				undelivered, err := store.LoadUndelivered(db, req.Username)
-				if err != nil {
-					log.Println("LoadUndelivered error:", err)
-				}
-				for _, row := range undelivered {
-					conn.WriteJSON(types.IncomingMessage{
-						Type:        "message",
-						From:        row.Sender,
-						To:          row.Recipient,
-						ContentType: row.ContentType,
-						Content:     row.Content,
-					})
+				if err := connectDevice(st, req.Username, ci); err != nil {
+					clog.Error("replay missed messages", "device", device, "err", err)
 				}
 
 			default:
-				conn.WriteJSON(map[string]string{"type": "error", "msg": "unknown action"})
+				sendError(conn, "action", "unknown action")
 			}
 
 		// ─── CHAT MESSAGES ────────────────────────────────────────────────────────
 		case "message":
 			var im types.IncomingMessage
 			if err := json.Unmarshal(rawMsg, &im); err != nil {
-				conn.WriteJSON(map[string]string{"type": "error", "msg": "bad message"})
+				sendError(conn, "message", "bad message")
 				continue
 			}
+			flog.Debug("frame received", "req", im)
 			// verify JWT
 			user, err := auth.ParseToken(im.Token)
 			if err != nil {
-				conn.WriteJSON(map[string]string{"type": "error", "msg": "invalid token"})
+				sendError(conn, "message", "invalid token")
 				continue
 			}
 			if im.From != user {
-				conn.WriteJSON(map[string]string{"type": "error", "msg": "sender mismatch"})
+				sendError(conn, "message", "sender mismatch")
 				continue
 			}
 			im.From = user
+			if len(im.ClientMsgID) > maxClientMsgID {
+				sendError(conn, "message", fmt.Sprintf("clientMsgId must be at most %d characters", maxClientMsgID))
+				continue
+			}
+			// "system" messages come from the server only (see handleTimer)
+			if im.ContentType != "text" && im.ContentType != "file" {
+				sendError(conn, "message", errBadContentType.Error())
+				continue
+			}
+			if im.Content == "" {
+				sendError(conn, "message", "content must not be empty")
+				continue
+			}
 
 			// persist
-			msgID, err := store.SaveMessage(db, im.From, im.To, im.ContentType, im.Content)
+			m, created, err := st.SaveMessage(im.From, im.To, im.ContentType, im.Content, im.ClientMsgID)
 			if err != nil {
-				log.Println("SaveMessage error:", err)
+				flog.Error("save message", "to", im.To, "err", err)
+				sendError(conn, "message", "internal error")
+				continue
 			}
-
-			// deliver to all online devices
-			for _, ci := range connections[im.To] {
-				ci.Conn.WriteJSON(im)
+			reply(conn, types.MessageSent{
+				Type:        "sent",
+				ID:          m.ID,
+				ClientMsgID: im.ClientMsgID,
+				SentAt:      m.SentAt,
+			})
+			if !created {
+				// a retry; everyone got it the first time
+				flog.Debug("duplicate send", "id", m.ID)
+				continue
 			}
+			out := messageFrame(m)
 
-			// mark delivered
-			if err := store.MarkDelivered(db, msgID); err != nil {
-				log.Println("MarkDelivered error:", err)
+			// deliver to all online devices; it counts as delivered once
+			// one of them acks
+			for _, ci := range connectionsFor(im.To) {
+				send(ci.Conn, out)
+			}
+			// and show it on the sender's other devices
+			if im.To != im.From {
+				for _, ci := range connectionsFor(im.From) {
+					if ci.Conn != conn {
+						send(ci.Conn, out)
+					}
+				}
 			}
 
 		// ─── HISTORY REQUEST ───────────────────────────────────────────────────────
 		case "history":
 			var req types.HistoryRequest
 			if err := json.Unmarshal(rawMsg, &req); err != nil {
-				conn.WriteJSON(map[string]string{"type": "error", "msg": "bad history request"})
+				sendError(conn, "history", "bad history request")
 				continue
 			}
+			flog.Debug("frame received", "req", req)
 			// authenticate
 			user, err := auth.ParseToken(req.Token)
 			if err != nil {
-				conn.WriteJSON(map[string]string{"type": "error", "msg": "invalid token"})
+				sendError(conn, "history", "invalid token")
 				continue
 			}
-			// load last N messages
-			rows, err := store.LoadHistory(db, user, req.ChatWith, req.Limit)
+			// load one page around the cursors
+			rows, hasMore, err := st.LoadHistory(user, req.ChatWith, store.Page{
+				Before: req.Before,
+				After:  req.After,
+				Limit:  req.Limit,
+			})
 			if err != nil {
-				log.Println("LoadHistory error:", err)
+				flog.Error("load history", "chatWith", req.ChatWith, "err", err)
+				sendError(conn, "history", "internal error")
 				continue
 			}
-			// send each back
-			for _, row := range rows {
-				conn.WriteJSON(types.IncomingMessage{
-					Type:        "message",
-					From:        row.Sender,
-					To:          row.Recipient,
-					ContentType: row.ContentType,
-					Content:     row.Content,
-				})
+			if rows == nil {
+				rows = []types.MessageRow{}
 			}
+			reply(conn, types.HistoryPage{
+				Type:     "historyPage",
+				ChatWith: req.ChatWith,
+				Messages: rows,
+				HasMore:  hasMore,
+			})
 		case "listChats":
 			//SEND A LIST OF USER'S CHATS
 			// 1) Unmarshal the request
 			var req types.ChatsRequest
 			if err := json.Unmarshal(rawMsg, &req); err != nil {
-				conn.WriteJSON(map[string]string{"type": "error", "msg": "bad chats request"})
+				sendError(conn, "listChats", "bad chats request")
 				continue
 			}
+			flog.Debug("frame received", "req", req)
 			// 2) Authenticate
 			me, err := auth.ParseToken(req.Token)
 			if err != nil {
-				conn.WriteJSON(map[string]string{"type": "error", "msg": "invalid token"})
+				sendError(conn, "listChats", "invalid token")
 				continue
 			}
 			// 3) Load from DB
-			chats, err := store.LoadChats(db, me)
+			chats, err := st.LoadChats(me)
 			if err != nil {
-				log.Println("LoadChats error:", err)
-				conn.WriteJSON(map[string]string{"type": "error", "msg": "internal error"})
+				flog.Error("load chats", "err", err)
+				sendError(conn, "listChats", "internal error")
 				continue
 			}
 			// 4) Send one single response containing all chats
-			conn.WriteJSON(map[string]interface{}{
+			reply(conn, map[string]interface{}{
 				"type":  "chatsList",
 				"chats": chats,
 			})
 
+		case "search":
+			handleSearch(conn, st, rawMsg, flog)
+
+		case "edit":
+			handleEdit(conn, st, rawMsg, flog)
+
+		case "delete":
+			handleDelete(conn, st, cfg.Messages.DeleteWindow.Duration, cfg.UploadDir, rawMsg, flog)
+
+		case "setTimer":
+			handleTimer(conn, st, rawMsg, flog)
+
+		case "markRead":
+			handleMarkRead(conn, st, rawMsg, flog)
+
+		case "ack":
+			handleAck(conn, st, loggedIn, device, rawMsg, flog)
+
 		// ─── UNKNOWN TYPE ─────────────────────────────────────────────────────────
 		default:
-			conn.WriteJSON(map[string]string{"type": "error", "msg": "unknown type"})
+			sendError(conn, t, "unknown type")
 		}
 	}
 }
+
+// sendError replies with an error frame and counts it against the frame
+// type that caused it.
+func sendError(conn *websocket.Conn, frame, msg string) {
+	errorsSent.Inc(frameLabel(frame))
+	reply(conn, map[string]string{"type": "error", "msg": msg})
+}
+
+// messageFrame is the "message" frame for a stored message.
+func messageFrame(m types.MessageRow) types.IncomingMessage {
+	sentAt := m.SentAt
+	return types.IncomingMessage{
+		Type:        "message",
+		ID:          m.ID,
+		From:        m.Sender,
+		To:          m.Recipient,
+		ContentType: m.ContentType,
+		Content:     m.Content,
+		SentAt:      &sentAt,
+	}
+}
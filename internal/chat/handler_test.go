@@ -0,0 +1,423 @@
+package chat_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/auth"
+	"github.com/jad0s/libretalk/internal/chat"
+	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/config"
+	"github.com/jad0s/libretalk/internal/types"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	err := auth.Configure(config.Auth{
+		JWTSecret: "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+		TokenTTL:  config.Duration{Duration: time.Hour},
+	})
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// password is what every test user logs in with.
+const password = "secret"
+
+// newServer serves chat.Handler over st with the given users.
+func newServer(t *testing.T, st store.Store, users ...string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range users {
+		if err := st.CreateUser(u, string(hash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := config.Default()
+	cfg.UploadDir = t.TempDir()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chat.Handler(w, r, st, cfg)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// client is one test socket.
+type client struct {
+	t     *testing.T
+	conn  *websocket.Conn
+	user  string
+	token string
+}
+
+type frame map[string]interface{}
+
+func dial(t *testing.T, url string) *client {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn}
+}
+
+// login dials a socket and logs user in on it, with a device ID unless
+// device is empty.
+func login(t *testing.T, url, user, device string) *client {
+	t.Helper()
+	c := dial(t, url)
+	c.send(types.ActionRequest{Type: "action", Action: "login", Username: user, Password: password, DeviceID: device})
+	f := c.expect("login")
+	c.user, c.token = user, f["token"].(string)
+	return c
+}
+
+func (c *client) send(v interface{}) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(v); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// next reads the next frame, skipping the server's pings.
+func (c *client) next() frame {
+	c.t.Helper()
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var f frame
+		if err := c.conn.ReadJSON(&f); err != nil {
+			c.t.Fatalf("%s: read: %v", c.user, err)
+		}
+		if f["type"] != "ping" {
+			return f
+		}
+	}
+}
+
+// expect reads the next frame and fails unless it has type typ.
+func (c *client) expect(typ string) frame {
+	c.t.Helper()
+	f := c.next()
+	if f["type"] != typ {
+		c.t.Fatalf("%s: got %v, want a %q frame", c.user, f, typ)
+	}
+	return f
+}
+
+// drain returns every frame queued for c so far. The server answers a
+// socket's frames in order, so they are whatever comes before the pong.
+func (c *client) drain() []frame {
+	c.t.Helper()
+	c.send(map[string]string{"type": "ping"})
+	var out []frame
+	for {
+		f := c.next()
+		if f["type"] == "pong" {
+			return out
+		}
+		out = append(out, f)
+	}
+}
+
+// sendText sends a text message to `to` and returns the "sent" answer.
+func (c *client) sendText(to, content, clientMsgID string) frame {
+	c.t.Helper()
+	c.send(types.IncomingMessage{
+		Type: "message", From: c.user, To: to, ContentType: "text",
+		Content: content, ClientMsgID: clientMsgID, Token: c.token,
+	})
+	return c.expect("sent")
+}
+
+func (c *client) ack(ids ...int64) {
+	c.t.Helper()
+	c.send(types.AckRequest{Type: "ack", Token: c.token, IDs: ids})
+}
+
+// id reads a frame's message ID.
+func id(f frame) int64 {
+	n, _ := f["id"].(float64)
+	return int64(n)
+}
+
+// messageIDs lists the IDs of the "message" frames among fs, failing on
+// anything else.
+func messageIDs(t *testing.T, fs []frame) []int64 {
+	t.Helper()
+	out := []int64{}
+	for _, f := range fs {
+		if f["type"] != "message" {
+			t.Fatalf("unexpected frame %v", f)
+		}
+		out = append(out, id(f))
+	}
+	return out
+}
+
+func TestSendAndDeliver(t *testing.T) {
+	url := newServer(t, store.NewMemory(), "alice", "bob")
+	alice := login(t, url, "alice", "")
+	bob := login(t, url, "bob", "")
+
+	sent := alice.sendText("bob", "hi", "c1")
+	if id(sent) == 0 || sent["clientMsgId"] != "c1" || sent["sentAt"] == nil {
+		t.Fatalf("sent = %v", sent)
+	}
+	m := bob.expect("message")
+	if id(m) != id(sent) || m["from"] != "alice" || m["to"] != "bob" || m["content"] != "hi" {
+		t.Errorf("message = %v, sent = %v", m, sent)
+	}
+	// the sender's own socket gets no copy
+	if fs := alice.drain(); len(fs) != 0 {
+		t.Errorf("sender got %v", fs)
+	}
+
+	for name, msg := range map[string]types.IncomingMessage{
+		"sender mismatch": {From: "bob", ContentType: "text", Content: "x"},
+		"content type":    {From: "alice", ContentType: "system", Content: "x"},
+		"empty":           {From: "alice", ContentType: "text"},
+	} {
+		msg.Type, msg.To, msg.Token = "message", "bob", alice.token
+		alice.send(msg)
+		if f := alice.expect("error"); f["msg"] == "" {
+			t.Errorf("%s: %v", name, f)
+		}
+	}
+	if fs := bob.drain(); len(fs) != 0 {
+		t.Errorf("rejected messages reached bob: %v", fs)
+	}
+}
+
+func TestClientMsgIDDedupe(t *testing.T) {
+	url := newServer(t, store.NewMemory(), "alice", "bob")
+	alice := login(t, url, "alice", "")
+	bob := login(t, url, "bob", "")
+
+	first := alice.sendText("bob", "hi", "c1")
+	retry := alice.sendText("bob", "hi", "c1")
+	if id(retry) != id(first) || retry["sentAt"] != first["sentAt"] {
+		t.Errorf("retry answered %v, first send %v", retry, first)
+	}
+	other := alice.sendText("bob", "hi", "c2")
+	if id(other) == id(first) {
+		t.Error("a new clientMsgId was deduplicated")
+	}
+	alice.drain() // both handlers are done with the fan-out
+	if got := messageIDs(t, bob.drain()); !slices.Equal(got, []int64{id(first), id(other)}) {
+		t.Errorf("bob got %v, want %d and %d once each", got, id(first), id(other))
+	}
+}
+
+func TestEchoToOtherSockets(t *testing.T) {
+	url := newServer(t, store.NewMemory(), "alice", "bob")
+	phone := login(t, url, "alice", "phone")
+	laptop := login(t, url, "alice", "laptop")
+	bob := login(t, url, "bob", "")
+
+	sent := phone.sendText("bob", "hi", "")
+	if m := laptop.expect("message"); id(m) != id(sent) || m["from"] != "alice" {
+		t.Errorf("laptop got %v", m)
+	}
+	if m := bob.expect("message"); id(m) != id(sent) {
+		t.Errorf("bob got %v", m)
+	}
+	if fs := phone.drain(); len(fs) != 0 {
+		t.Errorf("sending socket got %v", fs)
+	}
+
+	// a note to self goes to the other sockets once
+	self := laptop.sendText("alice", "note", "")
+	laptop.drain()
+	if got := messageIDs(t, phone.drain()); !slices.Equal(got, []int64{id(self)}) {
+		t.Errorf("phone got %v, want %d once", got, id(self))
+	}
+}
+
+func TestLoginReplay(t *testing.T) {
+	url := newServer(t, store.NewMemory(), "alice", "bob")
+	alice := login(t, url, "alice", "")
+	m1 := id(alice.sendText("bob", "one", ""))
+	m2 := id(alice.sendText("bob", "two", ""))
+
+	// without an ack the messages come again on every login
+	for i := 0; i < 2; i++ {
+		bob := login(t, url, "bob", "")
+		if got := messageIDs(t, bob.drain()); !slices.Equal(got, []int64{m1, m2}) {
+			t.Fatalf("login %d replayed %v, want %v", i, got, []int64{m1, m2})
+		}
+		bob.conn.Close()
+	}
+
+	bob := login(t, url, "bob", "")
+	bob.drain()
+	bob.ack(m1)
+	bob.drain()
+	bob.conn.Close()
+	bob = login(t, url, "bob", "")
+	if got := messageIDs(t, bob.drain()); !slices.Equal(got, []int64{m2}) {
+		t.Errorf("after acking %d: replayed %v, want [%d]", m1, got, m2)
+	}
+
+	// messages only go to the recipient
+	for _, f := range alice.drain() {
+		t.Errorf("sender got %v", f)
+	}
+}
+
+func TestDeviceReplay(t *testing.T) {
+	url := newServer(t, store.NewMemory(), "alice", "bob")
+	alice := login(t, url, "alice", "")
+	m1 := id(alice.sendText("bob", "one", ""))
+
+	phone := login(t, url, "bob", "phone")
+	if got := messageIDs(t, phone.drain()); !slices.Equal(got, []int64{m1}) {
+		t.Fatalf("phone replayed %v, want [%d]", got, m1)
+	}
+	phone.ack(m1)
+	phone.drain()
+	m2 := id(alice.sendText("bob", "two", ""))
+	if m := phone.expect("message"); id(m) != m2 {
+		t.Fatalf("phone got %v live, want %d", m, m2)
+	}
+	phone.conn.Close()
+
+	// the phone picks up where it acked; an ack from one device counts
+	// as delivered, so a new device only starts at what nobody got
+	phone = login(t, url, "bob", "phone")
+	if got := messageIDs(t, phone.drain()); !slices.Equal(got, []int64{m2}) {
+		t.Errorf("phone replayed %v, want [%d]", got, m2)
+	}
+	laptop := login(t, url, "bob", "laptop")
+	if got := messageIDs(t, laptop.drain()); !slices.Equal(got, []int64{m2}) {
+		t.Errorf("laptop replayed %v, want [%d]", got, m2)
+	}
+	phone.ack(m2)
+	phone.drain()
+	phone.conn.Close()
+	phone = login(t, url, "bob", "phone")
+	if fs := phone.drain(); len(fs) != 0 {
+		t.Errorf("phone replayed %v after acking everything", fs)
+	}
+}
+
+// gatedStore stops LoadUndelivered for one user until the test lets it
+// go on.
+type gatedStore struct {
+	store.Store
+	user    string
+	entered chan struct{}
+	proceed chan struct{}
+}
+
+func (g *gatedStore) LoadUndelivered(user string) ([]types.MessageRow, error) {
+	if user == g.user {
+		g.entered <- struct{}{}
+		<-g.proceed
+	}
+	return g.Store.LoadUndelivered(user)
+}
+
+func TestReplayBeforeLiveMessages(t *testing.T) {
+	st := &gatedStore{Store: store.NewMemory(), user: "bob", entered: make(chan struct{}), proceed: make(chan struct{})}
+	url := newServer(t, st, "alice", "bob")
+	alice := login(t, url, "alice", "")
+	m1 := id(alice.sendText("bob", "before", ""))
+
+	bob := dial(t, url)
+	bob.user = "bob"
+	bob.send(types.ActionRequest{Type: "action", Action: "login", Username: "bob", Password: password})
+	bob.expect("login")
+	<-st.entered
+	// bob's socket is registered but the replay isn't read yet: this one
+	// is held for bob and also part of the replay
+	m2 := id(alice.sendText("bob", "during", ""))
+	alice.drain()
+	close(st.proceed)
+
+	if got := messageIDs(t, bob.drain()); !slices.Equal(got, []int64{m1, m2}) {
+		t.Errorf("bob got %v, want %v in order and once each", got, []int64{m1, m2})
+	}
+	m3 := id(alice.sendText("bob", "after", ""))
+	if m := bob.expect("message"); id(m) != m3 {
+		t.Errorf("bob got %v after the replay, want %d", m, m3)
+	}
+}
+
+func TestAckValidation(t *testing.T) {
+	url := newServer(t, store.NewMemory(), "alice", "bob")
+	bob := login(t, url, "bob", "")
+	for name, req := range map[string]types.AckRequest{
+		"no ids":    {Token: bob.token},
+		"bad token": {Token: "x", IDs: []int64{1}},
+	} {
+		req.Type = "ack"
+		bob.send(req)
+		if f := bob.expect("error"); f["msg"] == "" {
+			t.Errorf("%s: %v", name, f)
+		}
+	}
+	bob.ack(make([]int64, 501)...)
+	bob.expect("error")
+}
+
+func TestAckForAnotherUser(t *testing.T) {
+	url := newServer(t, store.NewMemory(), "alice", "bob")
+	alice := login(t, url, "alice", "phone")
+	m1 := id(alice.sendText("bob", "one", ""))
+	phone := login(t, url, "bob", "phone")
+	phone.drain()
+
+	// alice's socket acks with bob's token, which must not touch bob's
+	// cursor for a device that happens to share the ID
+	alice.send(types.AckRequest{Type: "ack", Token: phone.token, IDs: []int64{m1}})
+	alice.expect("error")
+
+	phone.conn.Close()
+	phone = login(t, url, "bob", "phone")
+	if got := messageIDs(t, phone.drain()); !slices.Equal(got, []int64{m1}) {
+		t.Errorf("replayed %v after a rejected ack, want [%d]", got, m1)
+	}
+}
+
+func TestLoginTwiceOnOneSocket(t *testing.T) {
+	url := newServer(t, store.NewMemory(), "alice", "bob")
+	alice := login(t, url, "alice", "")
+	bob := login(t, url, "bob", "")
+	bob.send(types.ActionRequest{Type: "action", Action: "login", Username: "bob", Password: password})
+	bob.expect("login")
+	bob.drain()
+
+	sent := alice.sendText("bob", "hi", "")
+	alice.drain()
+	if got := messageIDs(t, bob.drain()); !slices.Equal(got, []int64{id(sent)}) {
+		t.Errorf("bob got %v, want [%d] once", got, id(sent))
+	}
+
+	alice.conn.Close()
+	bob.conn.Close()
+	// the handlers clean up once they see the sockets close
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		users, sockets := chat.ConnectionStats()
+		if users == 0 && sockets == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d users with %d sockets still registered", users, sockets)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,6 +1,318 @@
 package chat
 
-import "github.com/jad0s/libretalk/internal/types"
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
 
-// connections maps username -> all active connections for that user.
-var connections = make(map[string][]types.ConnectionInfo)
+	"github.com/jad0s/libretalk/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// sendQueue is how many frames may wait for a socket.
+	sendQueue = 256
+	// writeWait bounds a single write; a socket that takes longer is dead.
+	writeWait = 10 * time.Second
+	// pingEvery is how often the server pings a quiet socket.
+	pingEvery = 10 * time.Second
+)
+
+var (
+	// connMu guards connections, sockets and draining.
+	connMu sync.Mutex
+
+	// connections maps username -> all active connections for that user.
+	connections = make(map[string][]types.ConnectionInfo)
+
+	// sockets holds every upgraded socket, logged in or not, with its
+	// outgoing queue.
+	sockets = make(map[*websocket.Conn]*client)
+
+	// draining is set once Shutdown starts; no new sockets are accepted after that.
+	draining bool
+
+	// handlers counts running Handler loops so Shutdown can wait for them.
+	handlers sync.WaitGroup
+)
+
+// client is the write side of one socket. The websocket allows one writer
+// at a time, and frames for a socket come from its own handler and from
+// other users' handlers alike, so they are queued and written by a single
+// goroutine, which also sends the pings.
+type client struct {
+	conn  *websocket.Conn
+	queue chan interface{}
+	quit  chan struct{} // closed when the socket is untracked
+	done  chan struct{} // closed when the writer has stopped
+
+	mu      sync.Mutex
+	holding bool          // frames go to held until release
+	held    []interface{} // in the order they were sent
+}
+
+func (c *client) writeLoop() {
+	defer close(c.done)
+	ticker := time.NewTicker(pingEvery)
+	defer ticker.Stop()
+	for {
+		var v interface{}
+		select {
+		case v = <-c.queue:
+		case <-ticker.C:
+			v = map[string]string{"type": "ping"}
+		case <-c.quit:
+			return
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.conn.WriteJSON(v); err != nil {
+			// the read loop fails next and cleans up
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// enqueue queues v without waiting. A socket whose queue is full is
+// closed instead.
+func (c *client) enqueue(v interface{}) bool {
+	select {
+	case c.queue <- v:
+		return true
+	case <-c.done:
+		return false
+	default:
+		c.overflow()
+		return false
+	}
+}
+
+// wait queues v, waiting for room as long as the writer runs.
+func (c *client) wait(v interface{}) bool {
+	select {
+	case c.queue <- v:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *client) overflow() {
+	slog.Warn("send queue full, closing socket", "remote", c.conn.RemoteAddr().String())
+	c.conn.Close()
+}
+
+// trackSocket registers a freshly upgraded socket and starts its writer. It
+// returns false when the server is shutting down and the socket must be
+// turned away.
+func trackSocket(conn *websocket.Conn) bool {
+	connMu.Lock()
+	defer connMu.Unlock()
+	if draining {
+		return false
+	}
+	c := &client{
+		conn:  conn,
+		queue: make(chan interface{}, sendQueue),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	sockets[conn] = c
+	go c.writeLoop()
+	handlers.Add(1)
+	return true
+}
+
+// untrackSocket forgets conn and every user registration that points to it,
+// and stops its writer.
+func untrackSocket(conn *websocket.Conn) {
+	connMu.Lock()
+	defer connMu.Unlock()
+	if c := sockets[conn]; c != nil {
+		close(c.quit)
+	}
+	delete(sockets, conn)
+	for user, list := range connections {
+		kept := list[:0]
+		for _, ci := range list {
+			if ci.Conn != conn {
+				kept = append(kept, ci)
+			}
+		}
+		if len(kept) == 0 {
+			delete(connections, user)
+		} else {
+			connections[user] = kept
+		}
+	}
+	handlers.Done()
+}
+
+func clientFor(conn *websocket.Conn) *client {
+	connMu.Lock()
+	defer connMu.Unlock()
+	return sockets[conn]
+}
+
+// send queues v as one frame to conn without waiting. It is for frames to
+// other sockets than the one being handled: a socket that falls sendQueue
+// frames behind is closed rather than hold up the sender. It returns false
+// if the socket is gone.
+func send(conn *websocket.Conn, v interface{}) bool {
+	c := clientFor(conn)
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.holding {
+		if len(c.held) >= sendQueue {
+			c.overflow()
+			return false
+		}
+		c.held = append(c.held, v)
+		return true
+	}
+	return c.enqueue(v)
+}
+
+// reply queues v as one frame to the socket whose frame is being handled,
+// waiting for room, so a client sending faster than it reads is slowed
+// down rather than dropped.
+func reply(conn *websocket.Conn, v interface{}) bool {
+	c := clientFor(conn)
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	if c.holding {
+		c.held = append(c.held, v)
+		c.mu.Unlock()
+		return true
+	}
+	c.mu.Unlock()
+	return c.wait(v)
+}
+
+// hold makes frames sent to conn wait until release, so a login replay
+// can go out ahead of them.
+func hold(conn *websocket.Conn) {
+	if c := clientFor(conn); c != nil {
+		c.mu.Lock()
+		c.holding = true
+		c.mu.Unlock()
+	}
+}
+
+// release writes replay to conn, waiting for room in the queue as needed,
+// then the frames held since hold, leaving out "message" frames for the
+// messages the replay already carried.
+func release(conn *websocket.Conn, replay []types.IncomingMessage) {
+	c := clientFor(conn)
+	if c == nil {
+		return
+	}
+	replayed := make(map[int64]bool, len(replay))
+	for _, m := range replay {
+		if !c.wait(m) {
+			return
+		}
+		replayed[m.ID] = true
+	}
+	// more frames may be held while these go out; once none are left new
+	// ones go straight to the queue again
+	for {
+		c.mu.Lock()
+		held := c.held
+		c.held = nil
+		if len(held) == 0 {
+			c.holding = false
+		}
+		c.mu.Unlock()
+		if len(held) == 0 {
+			return
+		}
+		for _, v := range held {
+			if m, ok := v.(types.IncomingMessage); ok && replayed[m.ID] {
+				continue
+			}
+			if !c.wait(v) {
+				return
+			}
+		}
+	}
+}
+
+// addConnection registers ci as one of user's live devices. A socket that
+// logs in again stays registered once.
+func addConnection(user string, ci types.ConnectionInfo) {
+	connMu.Lock()
+	defer connMu.Unlock()
+	for _, c := range connections[user] {
+		if c.Conn == ci.Conn {
+			return
+		}
+	}
+	connections[user] = append(connections[user], ci)
+}
+
+// connectionsFor returns a snapshot of user's live connections.
+func connectionsFor(user string) []types.ConnectionInfo {
+	connMu.Lock()
+	defer connMu.Unlock()
+	return append([]types.ConnectionInfo(nil), connections[user]...)
+}
+
+// ConnectionStats returns how many users are logged in and how many
+// sockets they hold between them.
+func ConnectionStats() (users, sockets int) {
+	connMu.Lock()
+	defer connMu.Unlock()
+	for _, list := range connections {
+		sockets += len(list)
+	}
+	return len(connections), sockets
+}
+
+// Draining reports whether Shutdown has started.
+func Draining() bool {
+	connMu.Lock()
+	defer connMu.Unlock()
+	return draining
+}
+
+// Shutdown stops accepting new sockets, sends a "going away" close frame to
+// every open one and waits for their handlers to finish the frame they are
+// working on. Sockets still open when ctx expires are closed forcibly.
+func Shutdown(ctx context.Context) error {
+	connMu.Lock()
+	draining = true
+	open := make([]*websocket.Conn, 0, len(sockets))
+	for c := range sockets {
+		open = append(open, c)
+	}
+	connMu.Unlock()
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for _, c := range open {
+		// WriteControl is safe to call concurrently with the handler's writes
+		c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		for _, c := range open {
+			c.Close()
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,42 @@
+package chat
+
+import "github.com/jad0s/libretalk/internal/metrics"
+
+var (
+	framesHandled = metrics.NewCounterVec("libretalk_ws_frames_total",
+		"WebSocket frames received, by frame type.", "type")
+	errorsSent = metrics.NewCounterVec("libretalk_ws_error_responses_total",
+		"Error frames sent back to clients, by the frame type that caused them.", "type")
+	uploadsStored = metrics.NewCounter("libretalk_uploads_total",
+		"Files stored through /upload.")
+	uploadBytes = metrics.NewCounter("libretalk_upload_bytes_total",
+		"Bytes stored through /upload.")
+)
+
+func init() {
+	metrics.NewGaugeFunc("libretalk_connected_users",
+		"Users with at least one logged-in socket.",
+		func() float64 { users, _ := ConnectionStats(); return float64(users) })
+	metrics.NewGaugeFunc("libretalk_connected_sockets",
+		"Logged-in sockets across all users.",
+		func() float64 { _, socks := ConnectionStats(); return float64(socks) })
+}
+
+// knownFrames bounds the "type" label; anything else is counted as "unknown".
+var knownFrames = map[string]bool{
+	"ping": true, "pong": true, "action": true,
+	"message": true, "history": true, "listChats": true, "search": true,
+	"edit": true, "delete": true, "setTimer": true,
+	"markRead": true, "ack": true,
+}
+
+func frameLabel(t string) string {
+	switch {
+	case t == "":
+		return "invalid"
+	case knownFrames[t]:
+		return t
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,49 @@
+package chat
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/auth"
+	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// handleMarkRead answers a "markRead" frame: the caller's read cursor in
+// one conversation moves forward and the other side's live connections get
+// a "read" receipt.
+func handleMarkRead(conn *websocket.Conn, messages store.MessageStore, rawMsg []byte, flog *slog.Logger) {
+	var req types.MarkReadRequest
+	if err := json.Unmarshal(rawMsg, &req); err != nil {
+		sendError(conn, "markRead", "bad markRead request")
+		return
+	}
+	flog.Debug("frame received", "req", req)
+	user, err := auth.ParseToken(req.Token)
+	if err != nil {
+		sendError(conn, "markRead", "invalid token")
+		return
+	}
+	if req.ChatWith == "" {
+		sendError(conn, "markRead", "chatWith must not be empty")
+		return
+	}
+
+	upTo, err := messages.MarkRead(user, req.ChatWith, req.UpTo)
+	if err != nil {
+		flog.Error("mark read", "chatWith", req.ChatWith, "err", err)
+		sendError(conn, "markRead", "internal error")
+		return
+	}
+	// nothing new was read, so the sender has been told already
+	if upTo == 0 || req.ChatWith == user {
+		return
+	}
+	receipt := types.ReadReceipt{Type: "read", By: user, UpTo: upTo, ReadAt: time.Now().UTC()}
+	for _, ci := range connectionsFor(req.ChatWith) {
+		send(ci.Conn, receipt)
+	}
+}
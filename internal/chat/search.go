@@ -0,0 +1,139 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/auth"
+	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+var errBadContentType = errors.New("contentType must be text or file")
+
+// search runs req on behalf of user. Only conversations user takes part
+// in are searched, whatever the filters say.
+func search(messages store.MessageStore, user string, req types.SearchRequest) (types.SearchResults, error) {
+	rows, hasMore, err := messages.SearchMessages(user, store.SearchQuery{
+		Text:        req.Query,
+		ChatWith:    req.ChatWith,
+		From:        req.From,
+		Since:       req.Since,
+		Until:       req.Until,
+		ContentType: req.ContentType,
+		Before:      req.Before,
+		Limit:       req.Limit,
+	})
+	if err != nil {
+		return types.SearchResults{}, err
+	}
+	if rows == nil {
+		rows = []types.MessageRow{}
+	}
+	return types.SearchResults{Type: "searchResults", Messages: rows, HasMore: hasMore}, nil
+}
+
+func validateSearch(req types.SearchRequest) error {
+	switch req.ContentType {
+	case "", "text", "file":
+		return nil
+	}
+	return errBadContentType
+}
+
+// handleSearch answers a "search" frame.
+func handleSearch(conn *websocket.Conn, messages store.MessageStore, rawMsg []byte, flog *slog.Logger) {
+	var req types.SearchRequest
+	if err := json.Unmarshal(rawMsg, &req); err != nil {
+		sendError(conn, "search", "bad search request")
+		return
+	}
+	flog.Debug("frame received", "req", req)
+	user, err := auth.ParseToken(req.Token)
+	if err != nil {
+		sendError(conn, "search", "invalid token")
+		return
+	}
+	if err := validateSearch(req); err != nil {
+		sendError(conn, "search", err.Error())
+		return
+	}
+	res, err := search(messages, user, req)
+	if err != nil {
+		flog.Error("search", "err", err)
+		sendError(conn, "search", "internal error")
+		return
+	}
+	reply(conn, res)
+}
+
+// SearchHandler is the HTTP form of the "search" frame:
+// GET /search?q=&chatWith=&from=&since=&until=&contentType=&before=&limit=
+// with the JWT in an "Authorization: Bearer" header. Times are RFC 3339.
+func SearchHandler(messages store.MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user, ok := bearerUser(w, r)
+		if !ok {
+			return
+		}
+
+		q := r.URL.Query()
+		req := types.SearchRequest{
+			Query:       q.Get("q"),
+			ChatWith:    q.Get("chatWith"),
+			From:        q.Get("from"),
+			ContentType: q.Get("contentType"),
+		}
+		var err error
+		if req.Since, err = parseTimeParam(q.Get("since")); err != nil {
+			http.Error(w, "bad since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Until, err = parseTimeParam(q.Get("until")); err != nil {
+			http.Error(w, "bad until: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if v := q.Get("before"); v != "" {
+			if req.Before, err = strconv.ParseInt(v, 10, 64); err != nil {
+				http.Error(w, "bad before", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := q.Get("limit"); v != "" {
+			if req.Limit, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "bad limit", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := validateSearch(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		res, err := search(messages, user, req)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			slog.Error("search", "user", user, "err", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
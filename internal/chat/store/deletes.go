@@ -0,0 +1,94 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/types"
+)
+
+func (s *SQL) HideMessage(msgID int64, user string) (types.MessageRow, error) {
+	defer queryDuration.Since("HideMessage", time.Now())
+	m, err := scanMessage(s.queryRow("SELECT "+messageColumns+" FROM messages WHERE id = ?", msgID))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return types.MessageRow{}, ErrMessageNotFound
+	case err != nil:
+		return types.MessageRow{}, fmt.Errorf("hide message: %w", err)
+	case m.Sender != user && m.Recipient != user:
+		// other people's messages don't exist as far as user can tell
+		return types.MessageRow{}, ErrMessageNotFound
+	}
+	_, err = s.exec(`
+		INSERT INTO message_hidden (message_id, username, hidden_at)
+		VALUES (?, ?, ?)`,
+		msgID, user, now(),
+	)
+	if err != nil && !s.dialect.isDuplicate(err) {
+		return types.MessageRow{}, fmt.Errorf("hide message: %w", err)
+	}
+	return m, nil
+}
+
+func (s *SQL) DeleteMessage(msgID int64, sender string, window time.Duration) (types.MessageRow, string, error) {
+	defer queryDuration.Since("DeleteMessage", time.Now())
+	tx, err := s.db.Begin()
+	if err != nil {
+		return types.MessageRow{}, "", fmt.Errorf("delete message: %w", err)
+	}
+	defer tx.Rollback()
+	q := s.dialect.rebind
+
+	now := now()
+	m, err := scanMessage(tx.QueryRow(
+		q("SELECT "+messageColumns+" FROM messages WHERE id = ?"+s.dialect.forUpdate), msgID))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return types.MessageRow{}, "", ErrMessageNotFound
+	case err != nil:
+		return types.MessageRow{}, "", fmt.Errorf("delete message: %w", err)
+	case m.Sender != sender:
+		return types.MessageRow{}, "", ErrNotSender
+	case m.DeletedAt != nil:
+		return types.MessageRow{}, "", ErrMessageDeleted
+	case window > 0 && now.Sub(m.SentAt) > window:
+		return types.MessageRow{}, "", ErrDeleteExpired
+	}
+	// the upload is only known from the content about to be blanked
+	uploads := make(map[string]string)
+	if m.ContentType == "file" {
+		if name, id, ok := uploadName(m.Content); ok {
+			uploads[id] = name
+		}
+	}
+
+	if _, err := tx.Exec(q("UPDATE messages SET content = '', file_id = NULL, deleted_at = ? WHERE id = ?"),
+		now, msgID); err != nil {
+		return types.MessageRow{}, "", fmt.Errorf("delete message: %w", err)
+	}
+	// earlier versions would still hold the text
+	if _, err := tx.Exec(q("DELETE FROM message_edits WHERE message_id = ?"), msgID); err != nil {
+		return types.MessageRow{}, "", fmt.Errorf("delete previous versions: %w", err)
+	}
+	files, err := s.dropUnreferenced(tx, uploads)
+	if err != nil {
+		return types.MessageRow{}, "", err
+	}
+	user1, user2 := sortTwoUsers(m.Sender, m.Recipient)
+	if err := s.refreshSnippet(tx, user1, user2); err != nil {
+		return types.MessageRow{}, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return types.MessageRow{}, "", fmt.Errorf("delete message: %w", err)
+	}
+
+	m.Content = ""
+	m.DeletedAt = &now
+	var upload string
+	if len(files) > 0 {
+		upload = files[0]
+	}
+	return m, upload, nil
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/types"
+)
+
+func (s *SQL) RegisterDevice(user, deviceID string) (int64, error) {
+	defer queryDuration.Since("RegisterDevice", time.Now())
+	now := now()
+	var synced int64
+	err := s.queryRow("SELECT synced_id FROM devices WHERE username = ? AND device_id = ?",
+		user, deviceID).Scan(&synced)
+	switch {
+	case err == nil:
+		if _, err := s.exec("UPDATE devices SET last_seen_at = ? WHERE username = ? AND device_id = ?",
+			now, user, deviceID); err != nil {
+			return 0, fmt.Errorf("register device: %w", err)
+		}
+		return synced, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return 0, fmt.Errorf("register device: %w", err)
+	}
+
+	synced, err = s.newDeviceCursor(user)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.exec(`
+		INSERT INTO devices (username, device_id, synced_id, created_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		user, deviceID, synced, now, now,
+	)
+	if err != nil && s.dialect.isDuplicate(err) {
+		// the same device raced us from another socket; use its cursor
+		err = s.queryRow("SELECT synced_id FROM devices WHERE username = ? AND device_id = ?",
+			user, deviceID).Scan(&synced)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("register device: %w", err)
+	}
+	return synced, nil
+}
+
+// newDeviceCursor places a new device just before the oldest message to
+// user that no device has acked, or after the newest one if there is
+// none, so it catches up on what is pending but not on history.
+func (s *SQL) newDeviceCursor(user string) (int64, error) {
+	var oldest sql.NullInt64
+	if err := s.queryRow("SELECT MIN(id) FROM messages WHERE recipient = ? AND delivered = FALSE",
+		user).Scan(&oldest); err != nil {
+		return 0, fmt.Errorf("find undelivered: %w", err)
+	}
+	if oldest.Valid {
+		return oldest.Int64 - 1, nil
+	}
+	var newest sql.NullInt64
+	if err := s.queryRow("SELECT MAX(id) FROM messages WHERE recipient = ?",
+		user).Scan(&newest); err != nil {
+		return 0, fmt.Errorf("find newest message: %w", err)
+	}
+	return newest.Int64, nil
+}
+
+// notAcked is a WHERE condition on messages that leaves out those the
+// device has already acked above its cursor; it takes the username and
+// device ID as arguments.
+const notAcked = `NOT EXISTS (SELECT 1 FROM device_acks a
+                                 WHERE a.message_id = messages.id AND a.username = ? AND a.device_id = ?)`
+
+func (s *SQL) LoadSince(user, deviceID string, after int64) ([]types.MessageRow, error) {
+	defer queryDuration.Since("LoadSince", time.Now())
+	rows, err := s.query(`
+		SELECT `+messageColumns+`
+		  FROM messages
+		 WHERE recipient = ? AND id > ? AND deleted_at IS NULL
+		   AND `+notHidden+`
+		   AND `+notAcked+`
+		 ORDER BY id`,
+		user, after, user, user, deviceID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("load since: %w", err)
+	}
+	defer rows.Close()
+
+	var msgs []types.MessageRow
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan message: %w", err)
+		}
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load since: %w", err)
+	}
+	return msgs, nil
+}
+
+func (s *SQL) MarkSynced(user, deviceID string, msgIDs []int64) error {
+	defer queryDuration.Since("MarkSynced", time.Now())
+	if len(msgIDs) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("mark synced: %w", err)
+	}
+	defer tx.Rollback()
+	q := s.dialect.rebind
+
+	// the lock keeps two sockets of one device from interleaving here
+	var synced int64
+	err = tx.QueryRow(q("SELECT synced_id FROM devices WHERE username = ? AND device_id = ?"+s.dialect.forUpdate),
+		user, deviceID).Scan(&synced)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return fmt.Errorf("mark synced: %w", err)
+	}
+
+	// remember the acks above the cursor that aren't recorded yet
+	args := []interface{}{user, synced}
+	for _, id := range msgIDs {
+		args = append(args, id)
+	}
+	args = append(args, user, deviceID)
+	rows, err := tx.Query(q(`
+		SELECT id FROM messages
+		 WHERE recipient = ? AND id > ? AND id IN (`+placeholders(len(msgIDs))+`)
+		   AND `+notAcked),
+		args...)
+	if err != nil {
+		return fmt.Errorf("mark synced: %w", err)
+	}
+	var acked []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("mark synced: %w", err)
+		}
+		acked = append(acked, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("mark synced: %w", err)
+	}
+	for _, id := range acked {
+		if _, err := tx.Exec(q("INSERT INTO device_acks (username, device_id, message_id) VALUES (?, ?, ?)"),
+			user, deviceID, id); err != nil {
+			return fmt.Errorf("record ack: %w", err)
+		}
+	}
+
+	// The cursor moves up to just below the oldest message the device
+	// would still be sent, or to its newest ack if there is none, so an
+	// ack that arrives early or never comes can't skip anything.
+	var gap sql.NullInt64
+	if err := tx.QueryRow(q(`
+		SELECT MIN(id) FROM messages
+		 WHERE recipient = ? AND id > ? AND deleted_at IS NULL
+		   AND `+notHidden+`
+		   AND `+notAcked),
+		user, synced, user, user, deviceID).Scan(&gap); err != nil {
+		return fmt.Errorf("find oldest unacked: %w", err)
+	}
+	cursor := gap.Int64 - 1
+	if !gap.Valid {
+		var newest sql.NullInt64
+		if err := tx.QueryRow(q("SELECT MAX(message_id) FROM device_acks WHERE username = ? AND device_id = ?"),
+			user, deviceID).Scan(&newest); err != nil {
+			return fmt.Errorf("find newest ack: %w", err)
+		}
+		cursor = newest.Int64
+	}
+	if cursor > synced {
+		if _, err := tx.Exec(q("UPDATE devices SET synced_id = ? WHERE username = ? AND device_id = ?"),
+			cursor, user, deviceID); err != nil {
+			return fmt.Errorf("mark synced: %w", err)
+		}
+		// the cursor covers these now
+		if _, err := tx.Exec(q("DELETE FROM device_acks WHERE username = ? AND device_id = ? AND message_id <= ?"),
+			user, deviceID, cursor); err != nil {
+			return fmt.Errorf("drop covered acks: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("mark synced: %w", err)
+	}
+	return nil
+}
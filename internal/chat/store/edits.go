@@ -0,0 +1,69 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/types"
+)
+
+func (s *SQL) EditMessage(msgID int64, editor, content string) (types.MessageRow, error) {
+	defer queryDuration.Since("EditMessage", time.Now())
+	tx, err := s.db.Begin()
+	if err != nil {
+		return types.MessageRow{}, fmt.Errorf("edit message: %w", err)
+	}
+	defer tx.Rollback()
+	q := s.dialect.rebind
+
+	m, err := scanMessage(tx.QueryRow(
+		q("SELECT "+messageColumns+" FROM messages WHERE id = ?"+s.dialect.forUpdate), msgID))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return types.MessageRow{}, ErrMessageNotFound
+	case err != nil:
+		return types.MessageRow{}, fmt.Errorf("edit message: %w", err)
+	case m.Sender != editor:
+		return types.MessageRow{}, ErrNotSender
+	case m.DeletedAt != nil:
+		return types.MessageRow{}, ErrMessageDeleted
+	case m.ContentType != "text":
+		return types.MessageRow{}, ErrNotEditable
+	}
+
+	now := now()
+	if _, err := tx.Exec(q(`
+		INSERT INTO message_edits (message_id, old_content, edited_at)
+		VALUES (?, ?, ?)`),
+		msgID, m.Content, now,
+	); err != nil {
+		return types.MessageRow{}, fmt.Errorf("save previous version: %w", err)
+	}
+	if _, err := tx.Exec(q("UPDATE messages SET content = ?, edited_at = ? WHERE id = ?"),
+		content, now, msgID); err != nil {
+		return types.MessageRow{}, fmt.Errorf("edit message: %w", err)
+	}
+	// the chat list shows the newest message that wasn't deleted (see
+	// refreshSnippet), so only that one's edit changes the snippet
+	user1, user2 := sortTwoUsers(m.Sender, m.Recipient)
+	if _, err := tx.Exec(q(`
+		UPDATE conversations SET last_message = ?
+		 WHERE user1 = ? AND user2 = ?
+		   AND ? = (SELECT MAX(id) FROM messages
+		             WHERE ((sender = ? AND recipient = ?)
+		                 OR (sender = ? AND recipient = ?))
+		               AND deleted_at IS NULL)`),
+		content, user1, user2, msgID, user1, user2, user2, user1,
+	); err != nil {
+		return types.MessageRow{}, fmt.Errorf("update conversation: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return types.MessageRow{}, fmt.Errorf("edit message: %w", err)
+	}
+
+	m.Content = content
+	m.EditedAt = &now
+	return m, nil
+}
@@ -0,0 +1,22 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/types"
+)
+
+// SaveFile records an upload's metadata in the files table.
+func (s *SQL) SaveFile(f types.FileRow) error {
+	defer queryDuration.Since("SaveFile", time.Now())
+	_, err := s.exec(`
+		INSERT INTO files (id, uploader, original_name, content_type, size_bytes, uploaded_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		f.ID, f.Uploader, f.OriginalName, f.ContentType, f.SizeBytes, now(),
+	)
+	if err != nil {
+		return fmt.Errorf("insert file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,642 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/types"
+)
+
+// Memory is a Store that keeps everything in process memory. Nothing
+// survives a restart; it exists so the server can run without a database,
+// e.g. in tests or for a quick local try-out.
+type Memory struct {
+	mu            sync.Mutex
+	nextID        int64
+	messages      []memMessage // in insertion (= sent_at) order
+	conversations map[[2]string]*types.Chat
+	users         map[string]string // username -> password hash
+	files         map[string]types.FileRow
+	edits         []memEdit
+	hidden        map[memHidden]time.Time
+	reads         map[[2]string]int64      // {reader, peer} -> read cursor
+	devices       map[[2]string]*memDevice // {user, device}
+}
+
+type memDevice struct {
+	synced int64
+	acked  map[int64]bool // acks above synced
+}
+
+type memHidden struct {
+	messageID int64
+	username  string
+}
+
+type memEdit struct {
+	messageID  int64
+	oldContent string
+	editedAt   time.Time
+}
+
+type memMessage struct {
+	types.MessageRow
+	delivered   bool
+	deliveredAt time.Time
+	clientMsgID string
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		conversations: make(map[[2]string]*types.Chat),
+		users:         make(map[string]string),
+		files:         make(map[string]types.FileRow),
+		hidden:        make(map[memHidden]time.Time),
+		reads:         make(map[[2]string]int64),
+		devices:       make(map[[2]string]*memDevice),
+	}
+}
+
+func (m *Memory) PingContext(ctx context.Context) error { return nil }
+
+func (m *Memory) Close() error { return nil }
+
+func (m *Memory) SaveMessage(sender, recipient, contentType, content, clientMsgID string) (types.MessageRow, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if clientMsgID != "" {
+		for _, msg := range m.messages {
+			if msg.Sender == sender && msg.clientMsgID == clientMsgID {
+				return msg.MessageRow, false, nil
+			}
+		}
+	}
+	return m.insert(sender, recipient, contentType, content, clientMsgID), true, nil
+}
+
+// insert appends a new message, stamping expires_at from the
+// conversation's timer, and makes it the conversation's snippet. m.mu
+// must be held.
+func (m *Memory) insert(sender, recipient, contentType, content, clientMsgID string) types.MessageRow {
+	m.nextID++
+	now := now()
+	user1, user2 := sortTwoUsers(sender, recipient)
+	key := [2]string{user1, user2}
+	c := m.conversations[key]
+	if c == nil {
+		c = &types.Chat{}
+		m.conversations[key] = c
+	}
+	row := types.MessageRow{
+		ID:          m.nextID,
+		Sender:      sender,
+		Recipient:   recipient,
+		ContentType: contentType,
+		Content:     content,
+		SentAt:      now,
+	}
+	if c.DisappearAfter > 0 {
+		expires := now.Add(time.Duration(c.DisappearAfter) * time.Second)
+		row.ExpiresAt = &expires
+	}
+	m.messages = append(m.messages, memMessage{MessageRow: row, clientMsgID: clientMsgID})
+
+	c.LastMessage = content
+	c.LastMessageTime = now
+	return row
+}
+
+func (m *Memory) MarkDelivered(recipient string, msgIDs []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := now()
+	for _, id := range msgIDs {
+		if msg := m.find(id); msg != nil && msg.Recipient == recipient && !msg.delivered {
+			msg.delivered = true
+			msg.deliveredAt = now
+		}
+	}
+	return nil
+}
+
+func (m *Memory) LoadUndelivered(username string) ([]types.MessageRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var msgs []types.MessageRow
+	for _, msg := range m.messages {
+		if msg.Recipient == username && !msg.delivered && msg.DeletedAt == nil && !m.isHidden(msg.ID, username) {
+			msgs = append(msgs, msg.MessageRow)
+		}
+	}
+	return msgs, nil
+}
+
+func (m *Memory) RegisterDevice(user, deviceID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := [2]string{user, deviceID}
+	if d, ok := m.devices[key]; ok {
+		return d.synced, nil
+	}
+	var synced int64
+	for _, msg := range m.messages {
+		if msg.Recipient != user {
+			continue
+		}
+		if !msg.delivered {
+			synced = msg.ID - 1
+			break
+		}
+		synced = msg.ID
+	}
+	m.devices[key] = &memDevice{synced: synced, acked: make(map[int64]bool)}
+	return synced, nil
+}
+
+func (m *Memory) LoadSince(user, deviceID string, after int64) ([]types.MessageRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.devices[[2]string{user, deviceID}]
+	var msgs []types.MessageRow
+	for _, msg := range m.messages {
+		if msg.Recipient == user && msg.ID > after && msg.DeletedAt == nil && !m.isHidden(msg.ID, user) &&
+			(d == nil || !d.acked[msg.ID]) {
+			msgs = append(msgs, msg.MessageRow)
+		}
+	}
+	return msgs, nil
+}
+
+func (m *Memory) MarkSynced(user, deviceID string, msgIDs []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.devices[[2]string{user, deviceID}]
+	if !ok {
+		return nil
+	}
+	for _, id := range msgIDs {
+		if msg := m.find(id); msg != nil && msg.Recipient == user && id > d.synced {
+			d.acked[id] = true
+		}
+	}
+	// move over the acked run, stopping below the first message the
+	// device would still be sent
+	cursor, gap := d.synced, false
+	for _, msg := range m.messages {
+		if msg.Recipient != user || msg.ID <= d.synced || msg.DeletedAt != nil || m.isHidden(msg.ID, user) {
+			continue
+		}
+		if !d.acked[msg.ID] {
+			cursor, gap = msg.ID-1, true
+			break
+		}
+	}
+	if !gap {
+		for id := range d.acked {
+			cursor = max(cursor, id)
+		}
+	}
+	if cursor <= d.synced {
+		return nil
+	}
+	d.synced = cursor
+	for id := range d.acked {
+		if id <= cursor {
+			delete(d.acked, id)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) LoadHistory(user, withUser string, p Page) ([]types.MessageRow, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	match := func(msg types.MessageRow) bool {
+		return ((msg.Sender == user && msg.Recipient == withUser) ||
+			(msg.Sender == withUser && msg.Recipient == user)) &&
+			(p.Before <= 0 || msg.ID < p.Before) &&
+			(p.After <= 0 || msg.ID > p.After) &&
+			!m.isHidden(msg.ID, user)
+	}
+	// messages are kept in ID order; collect in walking order like the SQL
+	// store does, one past the limit
+	var msgs []types.MessageRow
+	n := p.limit() + 1
+	if p.forward() {
+		for i := 0; i < len(m.messages) && len(msgs) < n; i++ {
+			if match(m.messages[i].MessageRow) {
+				msgs = append(msgs, m.messages[i].MessageRow)
+			}
+		}
+	} else {
+		for i := len(m.messages) - 1; i >= 0 && len(msgs) < n; i-- {
+			if match(m.messages[i].MessageRow) {
+				msgs = append(msgs, m.messages[i].MessageRow)
+			}
+		}
+	}
+	msgs, hasMore := p.trim(msgs)
+	return msgs, hasMore, nil
+}
+
+func (m *Memory) LoadChats(me string) ([]types.Chat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var chats []types.Chat
+	for key, c := range m.conversations {
+		var peer string
+		switch me {
+		case key[0]:
+			peer = key[1]
+		case key[1]:
+			peer = key[0]
+		default:
+			continue
+		}
+		chat := *c
+		chat.With = peer
+		if peer != me {
+			cursor := m.reads[[2]string{me, peer}]
+			for _, msg := range m.messages {
+				if msg.Sender == peer && msg.Recipient == me && msg.ID > cursor && msg.DeletedAt == nil &&
+					!m.isHidden(msg.ID, me) {
+					chat.UnreadCount++
+				}
+			}
+		}
+		chats = append(chats, chat)
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		return chats[i].LastMessageTime.After(chats[j].LastMessageTime)
+	})
+	return chats, nil
+}
+
+func (m *Memory) CreateUser(username, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[username]; ok {
+		return ErrUserExists
+	}
+	m.users[username] = passwordHash
+	return nil
+}
+
+func (m *Memory) PasswordHash(username string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash, ok := m.users[username]
+	if !ok {
+		return "", ErrUserNotFound
+	}
+	return hash, nil
+}
+
+func (m *Memory) SetPasswordHash(username, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+	m.users[username] = passwordHash
+	return nil
+}
+
+func (m *Memory) DeleteUser(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+	delete(m.users, username)
+	return nil
+}
+
+func (m *Memory) ListUsers() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := make([]string, 0, len(m.users))
+	for u := range m.users {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+func (m *Memory) SaveFile(f types.FileRow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f.UploadedAt.IsZero() {
+		f.UploadedAt = now()
+	}
+	m.files[f.ID] = f
+	return nil
+}
+
+func (m *Memory) SearchMessages(user string, q SearchQuery) ([]types.MessageRow, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	words := q.words()
+	match := func(msg types.MessageRow) bool {
+		switch {
+		case msg.Sender != user && msg.Recipient != user:
+			return false
+		case msg.DeletedAt != nil || m.isHidden(msg.ID, user):
+			return false
+		case q.ChatWith != "" && msg.Sender != q.ChatWith && msg.Recipient != q.ChatWith:
+			return false
+		case q.ChatWith == user && msg.Sender != msg.Recipient:
+			return false
+		case q.From != "" && msg.Sender != q.From:
+			return false
+		case !q.Since.IsZero() && msg.SentAt.Before(q.Since):
+			return false
+		case !q.Until.IsZero() && !msg.SentAt.Before(q.Until):
+			return false
+		case q.ContentType != "" && msg.ContentType != q.ContentType:
+			return false
+		case q.Before > 0 && msg.ID >= q.Before:
+			return false
+		}
+		// every search word must start one of the message's words
+		have := SearchQuery{Text: msg.Content}.words()
+		for _, w := range words {
+			found := false
+			for _, h := range have {
+				if strings.HasPrefix(h, w) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	var msgs []types.MessageRow
+	for i := len(m.messages) - 1; i >= 0 && len(msgs) <= q.limit(); i-- {
+		if match(m.messages[i].MessageRow) {
+			msgs = append(msgs, m.messages[i].MessageRow)
+		}
+	}
+	hasMore := len(msgs) > q.limit()
+	if hasMore {
+		msgs = msgs[:q.limit()]
+	}
+	return msgs, hasMore, nil
+}
+
+func (m *Memory) EditMessage(msgID int64, editor, content string) (types.MessageRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg := m.find(msgID)
+	switch {
+	case msg == nil:
+		return types.MessageRow{}, ErrMessageNotFound
+	case msg.Sender != editor:
+		return types.MessageRow{}, ErrNotSender
+	case msg.DeletedAt != nil:
+		return types.MessageRow{}, ErrMessageDeleted
+	case msg.ContentType != "text":
+		return types.MessageRow{}, ErrNotEditable
+	}
+	now := now()
+	m.edits = append(m.edits, memEdit{messageID: msgID, oldContent: msg.Content, editedAt: now})
+	msg.Content = content
+	msg.EditedAt = &now
+
+	m.refreshSnippet(sortTwoUsers(msg.Sender, msg.Recipient))
+	return msg.MessageRow, nil
+}
+
+func (m *Memory) HideMessage(msgID int64, user string) (types.MessageRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg := m.find(msgID)
+	if msg == nil || (msg.Sender != user && msg.Recipient != user) {
+		return types.MessageRow{}, ErrMessageNotFound
+	}
+	key := memHidden{messageID: msgID, username: user}
+	if _, ok := m.hidden[key]; !ok {
+		m.hidden[key] = now()
+	}
+	return msg.MessageRow, nil
+}
+
+func (m *Memory) DeleteMessage(msgID int64, sender string, window time.Duration) (types.MessageRow, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := now()
+	msg := m.find(msgID)
+	switch {
+	case msg == nil:
+		return types.MessageRow{}, "", ErrMessageNotFound
+	case msg.Sender != sender:
+		return types.MessageRow{}, "", ErrNotSender
+	case msg.DeletedAt != nil:
+		return types.MessageRow{}, "", ErrMessageDeleted
+	case window > 0 && now.Sub(msg.SentAt) > window:
+		return types.MessageRow{}, "", ErrDeleteExpired
+	}
+	name, id, linked := uploadName(msg.Content)
+	linked = linked && msg.ContentType == "file"
+	msg.Content = ""
+	msg.DeletedAt = &now
+	edits := m.edits[:0]
+	for _, e := range m.edits {
+		if e.messageID != msgID {
+			edits = append(edits, e)
+		}
+	}
+	m.edits = edits
+
+	var upload string
+	if _, stored := m.files[id]; linked && stored && !m.fileReferenced(id) {
+		delete(m.files, id)
+		upload = name
+	}
+	m.refreshSnippet(sortTwoUsers(msg.Sender, msg.Recipient))
+	return msg.MessageRow, upload, nil
+}
+
+func (m *Memory) PurgeMessages(r Retention, now time.Time, limit int) (Purged, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.purge(func(msg memMessage) bool {
+		older := func(d time.Duration) bool { return d > 0 && msg.SentAt.Before(now.Add(-d)) }
+		return older(r.MaxAge) || older(r.ContentTypes[msg.ContentType]) ||
+			(msg.delivered && older(r.Delivered)) || (!msg.delivered && older(r.Undelivered))
+	}, limit), nil
+}
+
+func (m *Memory) SetDisappearing(user, withUser string, d time.Duration, notice string) (types.MessageRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user1, user2 := sortTwoUsers(user, withUser)
+	key := [2]string{user1, user2}
+	c := m.conversations[key]
+	if c == nil {
+		c = &types.Chat{}
+		m.conversations[key] = c
+	}
+	c.DisappearAfter = int64(d / time.Second)
+	return m.insert(user, withUser, "system", notice, ""), nil
+}
+
+func (m *Memory) PurgeExpired(now time.Time, limit int) (Purged, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.purge(func(msg memMessage) bool {
+		return msg.ExpiresAt != nil && !msg.ExpiresAt.After(now)
+	}, limit), nil
+}
+
+// purge removes up to limit messages for which expired is true, oldest
+// first, like the SQL store does. m.mu must be held.
+func (m *Memory) purge(expired func(memMessage) bool, limit int) Purged {
+	p := Purged{ByType: make(map[string]int)}
+	purged := make(map[int64]bool)
+	kept := m.messages[:0]
+	var gone []types.MessageRow
+	for _, msg := range m.messages {
+		if len(gone) < limit && expired(msg) {
+			gone = append(gone, msg.MessageRow)
+			purged[msg.ID] = true
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	m.messages = kept
+	if len(gone) == 0 {
+		return Purged{}
+	}
+	p.Messages = gone
+
+	edits := m.edits[:0]
+	for _, e := range m.edits {
+		if !purged[e.messageID] {
+			edits = append(edits, e)
+		}
+	}
+	m.edits = edits
+	for key := range m.hidden {
+		if purged[key.messageID] {
+			delete(m.hidden, key)
+		}
+	}
+
+	pairs := make(map[[2]string]bool)
+	for _, msg := range gone {
+		p.ByType[msg.ContentType]++
+		user1, user2 := sortTwoUsers(msg.Sender, msg.Recipient)
+		pairs[[2]string{user1, user2}] = true
+		if msg.ContentType != "file" {
+			continue
+		}
+		name, id, ok := uploadName(msg.Content)
+		if !ok || m.fileReferenced(id) {
+			continue
+		}
+		if _, ok := m.files[id]; ok {
+			delete(m.files, id)
+			p.Files = append(p.Files, name)
+		}
+	}
+	for pair := range pairs {
+		// an empty conversation still holds its disappearing timer
+		if c := m.conversations[pair]; c != nil && c.DisappearAfter == 0 &&
+			m.latestBetween(pair[0], pair[1]) == 0 {
+			delete(m.conversations, pair)
+			p.Conversations++
+			continue
+		}
+		m.refreshSnippet(pair[0], pair[1])
+	}
+	return p
+}
+
+func (m *Memory) MarkRead(user, withUser string, upTo int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var newest int64
+	for _, msg := range m.messages {
+		if msg.Sender == withUser && msg.Recipient == user && (upTo <= 0 || msg.ID <= upTo) {
+			newest = msg.ID
+		}
+	}
+	user1, user2 := sortTwoUsers(user, withUser)
+	if newest == 0 || m.conversations[[2]string{user1, user2}] == nil {
+		return 0, nil
+	}
+	key := [2]string{user, withUser}
+	if m.reads[key] >= newest {
+		return 0, nil
+	}
+	m.reads[key] = newest
+	return newest, nil
+}
+
+// fileReferenced reports whether any message still links to the upload
+// with files.id id. m.mu must be held.
+func (m *Memory) fileReferenced(id string) bool {
+	for _, msg := range m.messages {
+		if msg.ContentType != "file" {
+			continue
+		}
+		if _, ref, ok := uploadName(msg.Content); ok && ref == id {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshSnippet points the conversation's last message at the newest
+// message of the pair that is still there. m.mu must be held.
+func (m *Memory) refreshSnippet(user1, user2 string) {
+	c := m.conversations[[2]string{user1, user2}]
+	if c == nil {
+		return
+	}
+	c.LastMessage = ""
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := m.messages[i]
+		if msg.DeletedAt == nil && ((msg.Sender == user1 && msg.Recipient == user2) ||
+			(msg.Sender == user2 && msg.Recipient == user1)) {
+			c.LastMessage = msg.Content
+			return
+		}
+	}
+}
+
+// isHidden reports whether user deleted msgID for themselves. m.mu must
+// be held.
+func (m *Memory) isHidden(msgID int64, user string) bool {
+	_, ok := m.hidden[memHidden{messageID: msgID, username: user}]
+	return ok
+}
+
+// find returns the message with id, or nil. m.mu must be held.
+func (m *Memory) find(id int64) *memMessage {
+	i := sort.Search(len(m.messages), func(i int) bool { return m.messages[i].ID >= id })
+	if i < len(m.messages) && m.messages[i].ID == id {
+		return &m.messages[i]
+	}
+	return nil
+}
+
+// latestBetween returns the ID of the newest message between a and b, or
+// 0. m.mu must be held.
+func (m *Memory) latestBetween(a, b string) int64 {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := m.messages[i]
+		if (msg.Sender == a && msg.Recipient == b) || (msg.Sender == b && msg.Recipient == a) {
+			return msg.ID
+		}
+	}
+	return 0
+}
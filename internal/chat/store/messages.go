@@ -2,59 +2,114 @@ package store
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
-	"strings"
+	"log/slog"
 	"time"
 
 	"github.com/jad0s/libretalk/internal/types"
 )
 
-// SaveMessage writes a new message to db and returns its auto-increment ID.
-func SaveMessage(db *sql.DB, sender, recipient, contentType, content string) (int64, error) {
-	res, err := db.Exec(`
-		INSERT INTO messages (sender, recipient, content_type, content)
-		VALUES (?, ?, ?, ?)`,
-		sender, recipient, contentType, content,
+// SaveMessage writes a new message and returns it as stored, with its
+// auto-increment ID. With a disappearing timer on the conversation the
+// message gets an expires_at. A clientMsgID the sender already used
+// returns their earlier message instead, with created false.
+func (s *SQL) SaveMessage(sender, recipient, contentType, content, clientMsgID string) (types.MessageRow, bool, error) {
+	defer queryDuration.Since("SaveMessage", time.Now())
+	if clientMsgID != "" {
+		m, err := s.messageByClientID(sender, clientMsgID)
+		switch {
+		case err == nil:
+			return m, false, nil
+		case !errors.Is(err, sql.ErrNoRows):
+			return types.MessageRow{}, false, fmt.Errorf("save message: %w", err)
+		}
+	}
+	now := now()
+	user1, user2 := sortTwoUsers(sender, recipient)
+	var after int64
+	err := s.queryRow("SELECT disappear_after FROM conversations WHERE user1 = ? AND user2 = ?",
+		user1, user2).Scan(&after)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return types.MessageRow{}, false, fmt.Errorf("save message: %w", err)
+	}
+	m := types.MessageRow{
+		Sender:      sender,
+		Recipient:   recipient,
+		ContentType: contentType,
+		Content:     content,
+		SentAt:      now,
+	}
+	var expiresAt sql.NullTime
+	if after > 0 {
+		expiresAt = sql.NullTime{Time: now.Add(time.Duration(after) * time.Second), Valid: true}
+		m.ExpiresAt = &expiresAt.Time
+	}
+	m.ID, err = s.insertID(s.db, `
+		INSERT INTO messages (sender, recipient, content_type, content, sent_at, expires_at, client_msg_id, file_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		sender, recipient, contentType, content, now, expiresAt,
+		sql.NullString{String: clientMsgID, Valid: clientMsgID != ""},
+		fileID(contentType, content),
 	)
+	if err != nil && clientMsgID != "" && s.dialect.isDuplicate(err) {
+		// a retry got in between the lookup above and the insert
+		if m, err := s.messageByClientID(sender, clientMsgID); err == nil {
+			return m, false, nil
+		}
+	}
 	if err != nil {
-		return 0, fmt.Errorf("save message: %w", err)
+		return types.MessageRow{}, false, fmt.Errorf("save message: %w", err)
 	}
-	msgID, _ := res.LastInsertId()
-	user1, user2 := sortTwoUsers(sender, recipient)
-	if _, err := db.Exec(`
-		INSERT INTO conversations (user1, user2, last_message, updated_at)
-        VALUES (?, ?, ?, NOW())
-        ON DUPLICATE KEY UPDATE
-          last_message = VALUES(last_message),
-          updated_at   = VALUES(updated_at)
-    `, user1, user2, content); err != nil {
+	if _, err := s.exec(s.dialect.upsertConversation, user1, user2, content, now); err != nil {
 		// Log the error but don’t fail the whole send
-		log.Println("upsert conversation:", err)
+		slog.Warn("upsert conversation", "id", m.ID, "err", err)
 	}
-	return msgID, nil
+	return m, true, nil
+}
+
+// messageByClientID finds the message sender tagged with clientMsgID.
+func (s *SQL) messageByClientID(sender, clientMsgID string) (types.MessageRow, error) {
+	return scanMessage(s.queryRow(
+		"SELECT "+messageColumns+" FROM messages WHERE sender = ? AND client_msg_id = ?",
+		sender, clientMsgID))
 }
 
-// MarkDelivered flips the delivered flag and stamps delivered_at.
-func MarkDelivered(db *sql.DB, msgID int64) error {
-	_, err := db.Exec(
-		`UPDATE messages
-		 SET delivered = TRUE, delivered_at = ?
-		 WHERE id = ?`,
-		time.Now(), msgID,
+// MarkDelivered flips the delivered flag and stamps delivered_at. IDs of
+// messages sent to someone else are ignored.
+func (s *SQL) MarkDelivered(recipient string, msgIDs []int64) error {
+	defer queryDuration.Since("MarkDelivered", time.Now())
+	if len(msgIDs) == 0 {
+		return nil
+	}
+	args := []interface{}{now(), recipient}
+	for _, id := range msgIDs {
+		args = append(args, id)
+	}
+	_, err := s.exec(`
+		UPDATE messages
+		   SET delivered = TRUE, delivered_at = ?
+		 WHERE recipient = ? AND delivered = FALSE
+		   AND id IN (`+placeholders(len(msgIDs))+`)`,
+		args...,
 	)
-	return err
+	if err != nil {
+		return fmt.Errorf("mark delivered: %w", err)
+	}
+	return nil
 }
 
-// LoadUndelivered fetches all undelivered messages for a user,
-// in ascending sent_at order, and marks them delivered.
-func LoadUndelivered(db *sql.DB, username string) ([]types.MessageRow, error) {
-	rows, err := db.Query(`
-		SELECT id, sender, recipient, content_type, content, sent_at
+// LoadUndelivered fetches the messages sent to a user that no device has
+// acknowledged yet, in ID order. They stay undelivered until acked.
+func (s *SQL) LoadUndelivered(username string) ([]types.MessageRow, error) {
+	defer queryDuration.Since("LoadUndelivered", time.Now())
+	rows, err := s.query(`
+		SELECT `+messageColumns+`
 		  FROM messages
-		 WHERE recipient = ? AND delivered = FALSE
-	     ORDER BY sent_at`,
-		username,
+		 WHERE recipient = ? AND delivered = FALSE AND deleted_at IS NULL
+		   AND `+notHidden+`
+	     ORDER BY id`,
+		username, username,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("load undelivered: %w", err)
@@ -62,107 +117,95 @@ func LoadUndelivered(db *sql.DB, username string) ([]types.MessageRow, error) {
 	defer rows.Close()
 
 	var msgs []types.MessageRow
-	var ids []int64
 	for rows.Next() {
-		var m types.MessageRow
-		if err := rows.Scan(
-			&m.ID, &m.Sender, &m.Recipient,
-			&m.ContentType, &m.Content, &m.SentAt,
-		); err != nil {
+		m, err := scanMessage(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan message: %w", err)
 		}
 		msgs = append(msgs, m)
-		ids = append(ids, m.ID)
-	}
-
-	if len(ids) > 0 {
-		// Build "IN (?,?,...)" placeholder list
-		ph := strings.Repeat("?,", len(ids))
-		ph = ph[:len(ph)-1]
-		query := fmt.Sprintf(
-			"UPDATE messages SET delivered = TRUE, delivered_at = ? WHERE id IN (%s)",
-			ph,
-		)
-		args := make([]interface{}, len(ids)+1)
-		args[0] = time.Now()
-		for i, v := range ids {
-			args[i+1] = v
-		}
-		if _, err := db.Exec(query, args...); err != nil {
-			return nil, fmt.Errorf("mark delivered: %w", err)
-		}
 	}
-
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load undelivered: %w", err)
+	}
 	return msgs, nil
 }
 
-// LoadHistory fetches the most recent `limit` messages exchanged
-// between `user` and `withUser`, in chronological order (oldest first).
-func LoadHistory(db *sql.DB, user, withUser string, limit int) ([]types.MessageRow, error) {
-	// Query newest first, limited
-	rows, err := db.Query(`
-        SELECT id, sender, recipient, content_type, content, sent_at
+// LoadHistory fetches one page of the conversation between `user` and
+// `withUser`, in chronological order (oldest first). See Page for how the
+// cursors pick the page.
+func (s *SQL) LoadHistory(user, withUser string, p Page) ([]types.MessageRow, bool, error) {
+	defer queryDuration.Since("LoadHistory", time.Now())
+	query := `
+        SELECT ` + messageColumns + `
           FROM messages
-         WHERE (sender = ? AND recipient = ?)
-            OR (sender = ? AND recipient = ?)
-         ORDER BY sent_at DESC
-         LIMIT ?`,
-		user, withUser,
-		withUser, user,
-		limit,
-	)
+         WHERE ((sender = ? AND recipient = ?)
+            OR  (sender = ? AND recipient = ?))
+           AND ` + notHidden
+	args := []interface{}{user, withUser, withUser, user, user}
+	if p.Before > 0 {
+		query += " AND id < ?"
+		args = append(args, p.Before)
+	}
+	if p.After > 0 {
+		query += " AND id > ?"
+		args = append(args, p.After)
+	}
+	// walk away from the cursor; one extra row tells whether there is more
+	if p.forward() {
+		query += " ORDER BY id ASC LIMIT ?"
+	} else {
+		query += " ORDER BY id DESC LIMIT ?"
+	}
+	args = append(args, p.limit()+1)
+
+	rows, err := s.query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("load history: %w", err)
+		return nil, false, fmt.Errorf("load history: %w", err)
 	}
 	defer rows.Close()
 
 	var msgs []types.MessageRow
 	for rows.Next() {
-		var m types.MessageRow
-		var rawTime []byte // placeholder for the DATETIME column
-		if err := rows.Scan(
-			&m.ID,
-			&m.Sender,
-			&m.Recipient,
-			&m.ContentType,
-			&m.Content,
-			&rawTime,
-		); err != nil {
-			return nil, fmt.Errorf("scan history row: %w", err)
-		}
-
-		// MySQL DATETIME comes back as "YYYY-MM-DD HH:MM:SS"
-		t, err := time.Parse("2006-01-02 15:04:05", string(rawTime))
+		m, err := scanMessage(rows)
 		if err != nil {
-			return nil, fmt.Errorf("parse sent_at: %w", err)
+			return nil, false, fmt.Errorf("scan history row: %w", err)
 		}
-		m.SentAt = t
 
 		msgs = append(msgs, m)
 	}
-
-	// Reverse so oldest are first
-	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
-		msgs[i], msgs[j] = msgs[j], msgs[i]
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("load history: %w", err)
 	}
-
-	return msgs, nil
+	msgs, hasMore := p.trim(msgs)
+	return msgs, hasMore, nil
 }
 
-func LoadChats(db *sql.DB, me string) ([]types.Chat, error) {
+// LoadChats lists me's conversations, most recent first. Unread counts
+// come from idx_messages_unread: messages from the peer above me's read
+// cursor.
+func (s *SQL) LoadChats(me string) ([]types.Chat, error) {
+	defer queryDuration.Since("LoadChats", time.Now())
 	const q = `
 	  SELECT
 		CASE
-		  WHEN user1 = ? THEN user2
-		  ELSE user1
+		  WHEN c.user1 = ? THEN c.user2
+		  ELSE c.user1
 		END AS peer,
-		last_message,
-		updated_at
-	  FROM conversations
-	  WHERE user1 = ? OR user2 = ?
-	  ORDER BY updated_at DESC
+		c.last_message,
+		c.updated_at,
+		c.disappear_after,
+		(SELECT COUNT(*) FROM messages
+		  WHERE messages.recipient = ?
+		    AND messages.sender = CASE WHEN c.user1 = ? THEN c.user2 ELSE c.user1 END
+		    AND messages.sender <> messages.recipient
+		    AND messages.id > CASE WHEN c.user1 = ? THEN c.user1_read_id ELSE c.user2_read_id END
+		    AND messages.deleted_at IS NULL
+		    AND ` + notHidden + `) AS unread
+	  FROM conversations c
+	  WHERE c.user1 = ? OR c.user2 = ?
+	  ORDER BY c.updated_at DESC
 	`
-	rows, err := db.Query(q, me, me, me)
+	rows, err := s.query(q, me, me, me, me, me, me, me)
 	if err != nil {
 		return nil, fmt.Errorf("LoadChats query: %w", err)
 	}
@@ -171,7 +214,7 @@ func LoadChats(db *sql.DB, me string) ([]types.Chat, error) {
 	var chats []types.Chat
 	for rows.Next() {
 		var c types.Chat
-		if err := rows.Scan(&c.With, &c.LastMessage, &c.LastMessageTime); err != nil {
+		if err := rows.Scan(&c.With, &c.LastMessage, &c.LastMessageTime, &c.DisappearAfter, &c.UnreadCount); err != nil {
 			return nil, fmt.Errorf("LoadChats scan: %w", err)
 		}
 		chats = append(chats, c)
@@ -182,6 +225,34 @@ func LoadChats(db *sql.DB, me string) ([]types.Chat, error) {
 	return chats, nil
 }
 
+// messageColumns is the column list scanMessage expects.
+const messageColumns = "id, sender, recipient, content_type, content, sent_at, edited_at, deleted_at, expires_at"
+
+// notHidden is a WHERE condition, with the viewing user as its one
+// argument, that drops messages they deleted for themselves.
+const notHidden = `NOT EXISTS (SELECT 1 FROM message_hidden h
+                                 WHERE h.message_id = messages.id AND h.username = ?)`
+
+// scanMessage reads one row selected with messageColumns.
+func scanMessage(row interface{ Scan(...interface{}) error }) (types.MessageRow, error) {
+	var m types.MessageRow
+	var editedAt, deletedAt, expiresAt sql.NullTime
+	if err := row.Scan(&m.ID, &m.Sender, &m.Recipient, &m.ContentType, &m.Content, &m.SentAt,
+		&editedAt, &deletedAt, &expiresAt); err != nil {
+		return m, err
+	}
+	if editedAt.Valid {
+		m.EditedAt = &editedAt.Time
+	}
+	if deletedAt.Valid {
+		m.DeletedAt = &deletedAt.Time
+	}
+	if expiresAt.Valid {
+		m.ExpiresAt = &expiresAt.Time
+	}
+	return m, nil
+}
+
 func sortTwoUsers(a, b string) (string, string) {
 	if a < b {
 		return a, b
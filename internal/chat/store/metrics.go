@@ -0,0 +1,6 @@
+package store
+
+import "github.com/jad0s/libretalk/internal/metrics"
+
+var queryDuration = metrics.NewHistogramVec("libretalk_store_duration_seconds",
+	"Time spent in store calls, by operation.", "op", metrics.DefaultBuckets)
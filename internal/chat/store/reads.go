@@ -0,0 +1,47 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func (s *SQL) MarkRead(user, withUser string, upTo int64) (int64, error) {
+	defer queryDuration.Since("MarkRead", time.Now())
+	query := "SELECT MAX(id) FROM messages WHERE sender = ? AND recipient = ?"
+	args := []interface{}{withUser, user}
+	if upTo > 0 {
+		query += " AND id <= ?"
+		args = append(args, upTo)
+	}
+	var newest sql.NullInt64
+	if err := s.queryRow(query, args...).Scan(&newest); err != nil {
+		return 0, fmt.Errorf("mark read: %w", err)
+	}
+	if !newest.Valid {
+		return 0, nil
+	}
+
+	user1, user2 := sortTwoUsers(user, withUser)
+	col := readColumn(user, user1)
+	// the cursor only ever moves forward
+	res, err := s.exec(
+		"UPDATE conversations SET "+col+" = ? WHERE user1 = ? AND user2 = ? AND "+col+" < ?",
+		newest.Int64, user1, user2, newest.Int64)
+	if err != nil {
+		return 0, fmt.Errorf("mark read: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, nil
+	}
+	return newest.Int64, nil
+}
+
+// readColumn names user's read cursor column in a conversation whose
+// first user is user1.
+func readColumn(user, user1 string) string {
+	if user == user1 {
+		return "user1_read_id"
+	}
+	return "user2_read_id"
+}
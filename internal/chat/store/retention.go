@@ -0,0 +1,308 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/types"
+)
+
+// Retention says how long messages are kept, measured from sent_at. Zero
+// durations mean no limit; a message is purged once any limit that applies
+// to it has passed.
+type Retention struct {
+	MaxAge       time.Duration
+	ContentTypes map[string]time.Duration // by content type, e.g. "file"
+	Delivered    time.Duration
+	Undelivered  time.Duration
+}
+
+// IsZero reports whether the policy never purges anything.
+func (r Retention) IsZero() bool {
+	if r.MaxAge > 0 || r.Delivered > 0 || r.Undelivered > 0 {
+		return false
+	}
+	for _, d := range r.ContentTypes {
+		if d > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Purged describes one batch removed by PurgeMessages or PurgeExpired.
+type Purged struct {
+	Messages      []types.MessageRow // the rows removed, oldest first
+	ByType        map[string]int     // how many of them per content type
+	Conversations int                // conversations left empty and removed
+	// Files are the stored names of uploads whose files row went with the
+	// last message referring to them. Removing the blobs from the upload
+	// directory is up to the caller.
+	Files []string
+}
+
+// PurgeMessages removes up to limit messages that r says are expired as
+// of now, along with their edits, hidden markers and any upload no
+// remaining message refers to.
+func (s *SQL) PurgeMessages(r Retention, now time.Time, limit int) (Purged, error) {
+	defer queryDuration.Since("PurgeMessages", time.Now())
+	var conds []string
+	var args []interface{}
+	if r.MaxAge > 0 {
+		conds = append(conds, "sent_at < ?")
+		args = append(args, now.Add(-r.MaxAge).UTC())
+	}
+	for _, ct := range sortedTypes(r.ContentTypes) {
+		conds = append(conds, "(content_type = ? AND sent_at < ?)")
+		args = append(args, ct, now.Add(-r.ContentTypes[ct]).UTC())
+	}
+	if r.Delivered > 0 {
+		conds = append(conds, "(delivered = TRUE AND sent_at < ?)")
+		args = append(args, now.Add(-r.Delivered).UTC())
+	}
+	if r.Undelivered > 0 {
+		conds = append(conds, "(delivered = FALSE AND sent_at < ?)")
+		args = append(args, now.Add(-r.Undelivered).UTC())
+	}
+	if len(conds) == 0 {
+		return Purged{}, nil
+	}
+	return s.purge(strings.Join(conds, " OR "), args, limit)
+}
+
+// PurgeExpired removes up to limit messages whose disappearing timer ran
+// out by now, cleaning up like PurgeMessages.
+func (s *SQL) PurgeExpired(now time.Time, limit int) (Purged, error) {
+	defer queryDuration.Since("PurgeExpired", time.Now())
+	return s.purge("expires_at <= ?", []interface{}{now.UTC()}, limit)
+}
+
+// purge removes up to limit messages matching the WHERE condition where,
+// oldest first, and everything that only existed for their sake.
+func (s *SQL) purge(where string, args []interface{}, limit int) (Purged, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Purged{}, fmt.Errorf("purge messages: %w", err)
+	}
+	defer tx.Rollback()
+	q := s.dialect.rebind
+
+	rows, err := tx.Query(q(`
+		SELECT `+messageColumns+`
+		  FROM messages
+		 WHERE `+where+`
+		 ORDER BY id LIMIT ?`),
+		append(args, limit)...)
+	if err != nil {
+		return Purged{}, fmt.Errorf("purge messages: %w", err)
+	}
+	var msgs []types.MessageRow
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			rows.Close()
+			return Purged{}, fmt.Errorf("scan expired message: %w", err)
+		}
+		msgs = append(msgs, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Purged{}, fmt.Errorf("purge messages: %w", err)
+	}
+	if len(msgs) == 0 {
+		return Purged{}, nil
+	}
+
+	ids := make([]interface{}, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+	in := "(" + placeholders(len(ids)) + ")"
+	for _, stmt := range []string{
+		"DELETE FROM message_edits WHERE message_id IN " + in,
+		"DELETE FROM message_hidden WHERE message_id IN " + in,
+		"DELETE FROM device_acks WHERE message_id IN " + in,
+		"DELETE FROM messages WHERE id IN " + in,
+	} {
+		if _, err := tx.Exec(q(stmt), ids...); err != nil {
+			return Purged{}, fmt.Errorf("purge messages: %w", err)
+		}
+	}
+
+	p := Purged{Messages: msgs, ByType: make(map[string]int)}
+	pairs := make(map[[2]string]bool)
+	uploads := make(map[string]string) // files.id -> stored name
+	for _, m := range msgs {
+		p.ByType[m.ContentType]++
+		user1, user2 := sortTwoUsers(m.Sender, m.Recipient)
+		pairs[[2]string{user1, user2}] = true
+		if m.ContentType != "file" {
+			continue
+		}
+		if name, id, ok := uploadName(m.Content); ok {
+			uploads[id] = name
+		}
+	}
+	if p.Files, err = s.dropUnreferenced(tx, uploads); err != nil {
+		return Purged{}, err
+	}
+
+	for pair := range pairs {
+		var left int
+		if err := tx.QueryRow(q(`
+			SELECT COUNT(*) FROM messages
+			 WHERE (sender = ? AND recipient = ?) OR (sender = ? AND recipient = ?)`),
+			pair[0], pair[1], pair[1], pair[0]).Scan(&left); err != nil {
+			return Purged{}, fmt.Errorf("count conversation messages: %w", err)
+		}
+		if left == 0 {
+			// an empty conversation still holds its disappearing timer
+			res, err := tx.Exec(q(`
+				DELETE FROM conversations
+				 WHERE user1 = ? AND user2 = ? AND disappear_after = 0`),
+				pair[0], pair[1])
+			if err != nil {
+				return Purged{}, fmt.Errorf("purge conversation: %w", err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				p.Conversations++
+				continue
+			}
+		}
+		if err := s.refreshSnippet(tx, pair[0], pair[1]); err != nil {
+			return Purged{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Purged{}, fmt.Errorf("purge messages: %w", err)
+	}
+	return p, nil
+}
+
+// dropUnreferenced deletes the files rows of those uploads (files.id ->
+// stored name) no message refers to any more and returns their names.
+// Names that were never uploaded here are left out.
+func (s *SQL) dropUnreferenced(tx *sql.Tx, uploads map[string]string) ([]string, error) {
+	if len(uploads) == 0 {
+		return nil, nil
+	}
+	q := s.dialect.rebind
+	ids := make([]interface{}, 0, len(uploads))
+	for id := range uploads {
+		ids = append(ids, id)
+	}
+	in := "(" + placeholders(len(ids)) + ")"
+	referenced, err := queryStrings(tx, q("SELECT DISTINCT file_id FROM messages WHERE file_id IN "+in), ids...)
+	if err != nil {
+		return nil, fmt.Errorf("find file references: %w", err)
+	}
+	for _, id := range referenced {
+		delete(uploads, id)
+	}
+	if len(uploads) == 0 {
+		return nil, nil
+	}
+
+	ids = ids[:0]
+	for id := range uploads {
+		ids = append(ids, id)
+	}
+	in = "(" + placeholders(len(ids)) + ")"
+	stored, err := queryStrings(tx, q("SELECT id FROM files WHERE id IN "+in), ids...)
+	if err != nil {
+		return nil, fmt.Errorf("find files: %w", err)
+	}
+	if _, err := tx.Exec(q("DELETE FROM files WHERE id IN "+in), ids...); err != nil {
+		return nil, fmt.Errorf("purge files: %w", err)
+	}
+	names := make([]string, len(stored))
+	for i, id := range stored {
+		names[i] = uploads[id]
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// queryStrings runs a query selecting one string column on tx.
+func queryStrings(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// refreshSnippet points the conversation's last_message at the newest
+// message of the pair that is still there.
+func (s *SQL) refreshSnippet(tx *sql.Tx, user1, user2 string) error {
+	_, err := tx.Exec(s.dialect.rebind(`
+		UPDATE conversations SET last_message = COALESCE(
+		         (SELECT content FROM messages
+		           WHERE ((sender = ? AND recipient = ?) OR (sender = ? AND recipient = ?))
+		             AND deleted_at IS NULL
+		           ORDER BY id DESC LIMIT 1), '')
+		 WHERE user1 = ? AND user2 = ?`),
+		user1, user2, user2, user1, user1, user2,
+	)
+	if err != nil {
+		return fmt.Errorf("update conversation: %w", err)
+	}
+	return nil
+}
+
+// uploadName picks the stored file name ("<uuid>.<ext>") and files.id out
+// of a file message's content, which is the URL /upload handed out. The
+// message_files migrations backfill file_id by the same rule, and
+// TestFileIDBackfill holds the two together.
+func uploadName(content string) (name, id string, ok bool) {
+	i := strings.LastIndex(content, "/uploads/")
+	if i < 0 {
+		return "", "", false
+	}
+	name = content[i+len("/uploads/"):]
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return "", "", false
+	}
+	id, _, _ = strings.Cut(name, ".")
+	return name, id, true
+}
+
+// fileID is the files.id a message refers to: the upload a file message
+// links to, if any.
+func fileID(contentType, content string) sql.NullString {
+	if contentType != "file" {
+		return sql.NullString{}
+	}
+	_, id, ok := uploadName(content)
+	// files.id is a UUID; anything longer can't be an upload of ours
+	return sql.NullString{String: id, Valid: ok && len(id) <= 36}
+}
+
+// placeholders returns "?, ?, ..." with n entries.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func sortedTypes(m map[string]time.Duration) []string {
+	var keys []string
+	for k, d := range m {
+		if d > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
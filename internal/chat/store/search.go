@@ -0,0 +1,108 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jad0s/libretalk/internal/types"
+)
+
+// SearchQuery filters a user's messages. Every set field must match;
+// the zero value matches everything the user can see.
+type SearchQuery struct {
+	Text        string    // words that must all appear, each as a word prefix
+	ChatWith    string    // only the conversation with this user
+	From        string    // only messages sent by this user
+	Since       time.Time // sent at or after
+	Until       time.Time // sent before
+	ContentType string    // "text" or "file"
+	Before      int64     // only messages with a lower ID, for paging
+	Limit       int
+}
+
+// words splits the search text into the terms handed to the full-text
+// index. Anything but letters and digits separates words, which also keeps
+// the index's own query syntax out of user input.
+func (q SearchQuery) words() []string {
+	return strings.FieldsFunc(strings.ToLower(q.Text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (q SearchQuery) limit() int { return Page{Limit: q.Limit}.limit() }
+
+// SearchMessages returns the newest messages matching q among those user
+// sent or received, newest first, and whether older matches exist.
+func (s *SQL) SearchMessages(user string, q SearchQuery) ([]types.MessageRow, bool, error) {
+	defer queryDuration.Since("SearchMessages", time.Now())
+	query := `
+		SELECT ` + messageColumns + `
+		  FROM messages
+		 WHERE (sender = ? OR recipient = ?)
+		   AND deleted_at IS NULL AND ` + notHidden
+	args := []interface{}{user, user, user}
+	if q.ChatWith != "" {
+		query += " AND ((sender = ? AND recipient = ?) OR (sender = ? AND recipient = ?))"
+		args = append(args, user, q.ChatWith, q.ChatWith, user)
+	}
+	if q.From != "" {
+		query += " AND sender = ?"
+		args = append(args, q.From)
+	}
+	if !q.Since.IsZero() {
+		query += " AND sent_at >= ?"
+		args = append(args, q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		query += " AND sent_at < ?"
+		args = append(args, q.Until.UTC())
+	}
+	if q.ContentType != "" {
+		query += " AND content_type = ?"
+		args = append(args, q.ContentType)
+	}
+	if words := q.words(); len(words) > 0 {
+		query += " AND " + s.dialect.textMatch
+		args = append(args, s.dialect.textQuery(words))
+	}
+	if q.Before > 0 {
+		query += " AND id < ?"
+		args = append(args, q.Before)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, q.limit()+1)
+
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("search messages: %w", err)
+	}
+	defer rows.Close()
+	var msgs []types.MessageRow
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, false, fmt.Errorf("scan search row: %w", err)
+		}
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("search messages: %w", err)
+	}
+	hasMore := len(msgs) > q.limit()
+	if hasMore {
+		msgs = msgs[:q.limit()]
+	}
+	return msgs, hasMore, nil
+}
+
+// prefixTerms renders words as "w1* w2*", the prefix syntax shared by
+// MySQL boolean mode (with a leading +) and SQLite FTS.
+func prefixTerms(words []string, must string) string {
+	terms := make([]string, len(words))
+	for i, w := range words {
+		terms[i] = must + w + "*"
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jad0s/libretalk/internal/db"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQL is the Store backed by a relational database with the schema from
+// internal/migrate. The queries are shared; dialect covers the few
+// statements each database spells differently.
+type SQL struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// dialect holds what differs between the supported SQL databases.
+type dialect struct {
+	name string
+	// upsertConversation inserts (user1, user2, last_message, updated_at)
+	// or updates the last two columns when the pair already exists.
+	upsertConversation string
+	// isDuplicate reports whether err is a unique-key violation.
+	isDuplicate func(err error) bool
+	// textMatch is a WHERE condition with one placeholder that matches
+	// messages against the full-text index; textQuery builds its argument
+	// from the search words.
+	textMatch string
+	textQuery func(words []string) string
+	// forUpdate is appended to a SELECT to lock the rows it reads until
+	// the transaction ends; SQLite locks the whole database instead.
+	forUpdate string
+	// numbered placeholders ($1, $2, ...) instead of ?; new IDs come
+	// back through RETURNING instead of LastInsertId.
+	numbered bool
+}
+
+var mysqlDialect = dialect{
+	name: "mysql",
+	upsertConversation: `
+		INSERT INTO conversations (user1, user2, last_message, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+		  last_message = VALUES(last_message),
+		  updated_at   = VALUES(updated_at)`,
+	isDuplicate: func(err error) bool {
+		var me *mysql.MySQLError
+		return errors.As(err, &me) && me.Number == 1062
+	},
+	forUpdate: " FOR UPDATE",
+	// InnoDB leaves words shorter than innodb_ft_min_token_size (3) and,
+	// unless innodb_ft_enable_stopword is off, stopwords out of the
+	// index. The terms still match longer words by prefix, but a message
+	// is never found by such a word itself; the README says how to set
+	// the server up to index them
+	textMatch: "MATCH(content) AGAINST (? IN BOOLEAN MODE)",
+	textQuery: func(words []string) string { return prefixTerms(words, "+") },
+}
+
+var sqliteDialect = dialect{
+	name: "sqlite",
+	upsertConversation: `
+		INSERT INTO conversations (user1, user2, last_message, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user1, user2) DO UPDATE SET
+		  last_message = excluded.last_message,
+		  updated_at   = excluded.updated_at`,
+	isDuplicate: func(err error) bool {
+		var se sqlite3.Error
+		return errors.As(err, &se) && (se.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			se.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+	},
+	textMatch: "id IN (SELECT docid FROM messages_fts WHERE messages_fts MATCH ?)",
+	textQuery: func(words []string) string { return prefixTerms(words, "") },
+}
+
+var postgresDialect = dialect{
+	name:               "postgres",
+	upsertConversation: sqliteDialect.upsertConversation,
+	isDuplicate: func(err error) bool {
+		var pe *pq.Error
+		return errors.As(err, &pe) && pe.Code == "23505" // unique_violation
+	},
+	forUpdate: " FOR UPDATE",
+	// must match the expression of idx_messages_content_fts to use it
+	textMatch: "to_tsvector('simple', content) @@ to_tsquery('simple', ?)",
+	textQuery: func(words []string) string {
+		terms := make([]string, len(words))
+		for i, w := range words {
+			terms[i] = w + ":*"
+		}
+		return strings.Join(terms, " & ")
+	},
+	numbered: true,
+}
+
+// NewMySQL wraps an open MySQL pool. The store takes ownership of db and
+// closes it in Close.
+func NewMySQL(db *sql.DB) *SQL {
+	return &SQL{db: db, dialect: mysqlDialect}
+}
+
+// NewSQLite wraps an open SQLite database, see NewMySQL.
+func NewSQLite(db *sql.DB) *SQL {
+	return &SQL{db: db, dialect: sqliteDialect}
+}
+
+// NewPostgres wraps an open PostgreSQL pool, see NewMySQL.
+func NewPostgres(db *sql.DB) *SQL {
+	return &SQL{db: db, dialect: postgresDialect}
+}
+
+// DB returns the underlying pool, e.g. for running migrations.
+func (s *SQL) DB() *sql.DB { return s.db }
+
+// Driver names the database behind the store: "mysql", "sqlite" or "postgres".
+func (s *SQL) Driver() string { return s.dialect.name }
+
+func (s *SQL) PingContext(ctx context.Context) error { return s.db.PingContext(ctx) }
+
+func (s *SQL) Close() error { return s.db.Close() }
+
+// Queries are written with ? placeholders; these helpers rewrite them for
+// the dialect before handing them to database/sql.
+
+func (s *SQL) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.dialect.rebind(query), args...)
+}
+
+func (s *SQL) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.rebind(query), args...)
+}
+
+func (s *SQL) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.dialect.rebind(query), args...)
+}
+
+// execer is what insertID needs of a *sql.DB or *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// insertID runs an INSERT on e and returns the id of the new row.
+func (s *SQL) insertID(e execer, query string, args ...interface{}) (int64, error) {
+	if s.dialect.numbered {
+		var id int64
+		err := e.QueryRow(s.dialect.rebind(query+" RETURNING id"), args...).Scan(&id)
+		return id, err
+	}
+	res, err := e.Exec(s.dialect.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// rebind turns ? placeholders into $1, $2, ... where the dialect needs it.
+func (d dialect) rebind(query string) string {
+	return db.Rebind(d.name, query)
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/types"
+)
+
+var (
+	// ErrUserNotFound is returned when no user has the given name.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists is returned when registering a name that is taken.
+	ErrUserExists = errors.New("username already taken")
+	// ErrMessageNotFound is returned for an unknown message ID.
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrNotSender is returned when someone other than the sender tries to
+	// change a message.
+	ErrNotSender = errors.New("only the sender can change a message")
+	// ErrNotEditable is returned when editing anything but a text message.
+	ErrNotEditable = errors.New("only text messages can be edited")
+	// ErrMessageDeleted is returned when changing a message that was
+	// already deleted for everyone.
+	ErrMessageDeleted = errors.New("message was deleted")
+	// ErrDeleteExpired is returned when the window for deleting a message
+	// for everyone has passed.
+	ErrDeleteExpired = errors.New("message is too old to delete for everyone")
+)
+
+// MessageStore persists direct messages and the per-pair conversation
+// summaries shown in the chat list.
+type MessageStore interface {
+	// SaveMessage stores a new message and returns it with the ID and
+	// time the store gave it. When sender already sent a message tagged
+	// with the non-empty clientMsgID, that one is returned instead and
+	// created is false.
+	SaveMessage(sender, recipient, contentType, content, clientMsgID string) (m types.MessageRow, created bool, err error)
+	// MarkDelivered flips the delivered flag and stamps delivered_at on
+	// those of msgIDs that were sent to recipient, once their device has
+	// acknowledged them.
+	MarkDelivered(recipient string, msgIDs []int64) error
+	// LoadUndelivered returns the messages sent to username that no
+	// device has acknowledged yet, oldest first, leaving out deleted ones
+	// and those username hid.
+	LoadUndelivered(username string) ([]types.MessageRow, error)
+	// RegisterDevice records deviceID as one of user's devices if it is
+	// new and returns its sync cursor: the newest message to user it has
+	// acknowledged. A new device starts just before the oldest message no
+	// device has acknowledged, so it gets those but not older history.
+	RegisterDevice(user, deviceID string) (int64, error)
+	// LoadSince returns the messages sent to user with an ID above after,
+	// oldest first, leaving out deleted and hidden ones and those the
+	// device already acked.
+	LoadSince(user, deviceID string, after int64) ([]types.MessageRow, error)
+	// MarkSynced records that user's device acked those of msgIDs sent to
+	// user and moves its sync cursor forward over every message below
+	// the oldest one it has yet to ack.
+	MarkSynced(user, deviceID string, msgIDs []int64) error
+	// LoadHistory returns one page of the messages between two users,
+	// oldest first, and whether there are more beyond it.
+	LoadHistory(user, withUser string, p Page) ([]types.MessageRow, bool, error)
+	// LoadChats lists a user's conversations, most recently active first.
+	LoadChats(me string) ([]types.Chat, error)
+	// SearchMessages finds messages user sent or received, newest first,
+	// and reports whether older matches exist.
+	SearchMessages(user string, q SearchQuery) ([]types.MessageRow, bool, error)
+	// EditMessage replaces the content of a text message sent by editor,
+	// keeping the previous version, and returns the updated message.
+	EditMessage(msgID int64, editor, content string) (types.MessageRow, error)
+	// HideMessage removes a message from user's own history, leaving it
+	// in place for the other participant.
+	HideMessage(msgID int64, user string) (types.MessageRow, error)
+	// DeleteMessage turns a message sent by sender into a tombstone for
+	// both participants. A positive window limits how long after sending
+	// that is allowed. When it linked to an upload no other message links
+	// to, the files row goes too and upload is the stored name; removing
+	// the blob is up to the caller.
+	DeleteMessage(msgID int64, sender string, window time.Duration) (m types.MessageRow, upload string, err error)
+	// PurgeMessages removes up to limit messages that have outlived r as
+	// of now, oldest first.
+	PurgeMessages(r Retention, now time.Time, limit int) (Purged, error)
+	// SetDisappearing sets the disappearing timer of the conversation
+	// between two users; messages sent from then on expire after d.
+	// Zero turns it off. In the same step it saves notice as a "system"
+	// message from user to withUser and returns it; the notice is the
+	// first message under the new timer, so it expires after d as well.
+	SetDisappearing(user, withUser string, d time.Duration, notice string) (types.MessageRow, error)
+	// PurgeExpired removes up to limit messages whose disappearing
+	// timer has run out by now, oldest first.
+	PurgeExpired(now time.Time, limit int) (Purged, error)
+	// MarkRead moves user's read cursor in the conversation with withUser
+	// to the newest message withUser sent them at or below upTo (any, for
+	// upTo 0). It returns the new cursor, or 0 when it didn't move.
+	MarkRead(user, withUser string, upTo int64) (int64, error)
+}
+
+// UserStore keeps accounts and their bcrypt password hashes. Hashing and
+// checking passwords is left to the auth package.
+type UserStore interface {
+	CreateUser(username, passwordHash string) error
+	PasswordHash(username string) (string, error)
+	SetPasswordHash(username, passwordHash string) error
+	DeleteUser(username string) error
+	ListUsers() ([]string, error)
+}
+
+// FileStore records metadata of uploaded files; the blobs themselves live
+// in the upload directory.
+type FileStore interface {
+	SaveFile(f types.FileRow) error
+}
+
+// Store is everything the server needs from its storage backend.
+type Store interface {
+	MessageStore
+	UserStore
+	FileStore
+	PingContext(ctx context.Context) error
+	Close() error
+}
+
+var (
+	_ Store = (*SQL)(nil)
+	_ Store = (*Memory)(nil)
+)
+
+// now is the time every backend stamps rows with: UTC in whole seconds,
+// which is all a MySQL DATETIME keeps, so a time handed out live matches
+// what a later read returns whichever backend is in use.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// Page limits a listing to a window of message IDs. With no cursor it is
+// the newest Limit messages; Before pages back to older ones, After pages
+// forward to newer ones. hasMore results refer to the side being walked
+// towards: older messages, or newer ones when After is set.
+type Page struct {
+	Before int64
+	After  int64
+	Limit  int
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+func (p Page) limit() int {
+	switch {
+	case p.Limit <= 0:
+		return defaultPageLimit
+	case p.Limit > maxPageLimit:
+		return maxPageLimit
+	}
+	return p.Limit
+}
+
+// forward reports whether the page is read oldest-first from After.
+func (p Page) forward() bool { return p.After > 0 }
+
+// trim takes rows fetched in walking order, at most limit()+1 of them,
+// drops the extra one and returns the page oldest first.
+func (p Page) trim(msgs []types.MessageRow) ([]types.MessageRow, bool) {
+	hasMore := len(msgs) > p.limit()
+	if hasMore {
+		msgs = msgs[:p.limit()]
+	}
+	if !p.forward() {
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+	}
+	return msgs, hasMore
+}
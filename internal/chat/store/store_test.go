@@ -0,0 +1,606 @@
+package store_test
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/db"
+	"github.com/jad0s/libretalk/internal/migrate"
+	"github.com/jad0s/libretalk/internal/types"
+)
+
+// backends opens an empty store of each kind that runs without a server.
+var backends = map[string]func(t *testing.T) store.Store{
+	"memory": func(t *testing.T) store.Store { return store.NewMemory() },
+	"sqlite": func(t *testing.T) store.Store {
+		database, err := db.Connect("sqlite", filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := migrate.Up(database, "sqlite"); err != nil {
+			t.Fatal(err)
+		}
+		s := store.NewSQLite(database)
+		t.Cleanup(func() { s.Close() })
+		return s
+	},
+}
+
+// contract is what every Store must do the same way.
+var contract = []struct {
+	name string
+	run  func(t *testing.T, st store.Store)
+}{
+	{"users", testUsers},
+	{"history pages", testHistoryPages},
+	{"sent at", testSentAt},
+	{"delivery", testDelivery},
+	{"client message IDs", testClientMsgID},
+	{"edit", testEdit},
+	{"delete", testDelete},
+	{"chats and reads", testChatsAndReads},
+	{"disappearing", testDisappearing},
+	{"retention", testRetention},
+	{"devices", testDevices},
+	{"acks out of order", testAcksOutOfOrder},
+	{"search", testSearch},
+}
+
+func TestStore(t *testing.T) {
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			for _, c := range contract {
+				t.Run(c.name, func(t *testing.T) { c.run(t, open(t)) })
+			}
+		})
+	}
+}
+
+// send saves a text message and fails the test if that doesn't work.
+func send(t *testing.T, st store.Store, from, to, content string) types.MessageRow {
+	t.Helper()
+	m, created, err := st.SaveMessage(from, to, "text", content, "")
+	if err != nil || !created {
+		t.Fatalf("SaveMessage(%s -> %s): created %v, %v", from, to, created, err)
+	}
+	return m
+}
+
+// ids lists the IDs of msgs in order.
+func ids(msgs []types.MessageRow) []int64 {
+	out := []int64{}
+	for _, m := range msgs {
+		out = append(out, m.ID)
+	}
+	return out
+}
+
+func testUsers(t *testing.T, st store.Store) {
+	if err := st.CreateUser("bob", "h1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreateUser("alice", "h2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreateUser("bob", "h3"); !errors.Is(err, store.ErrUserExists) {
+		t.Errorf("duplicate CreateUser: %v, want ErrUserExists", err)
+	}
+	if h, err := st.PasswordHash("bob"); err != nil || h != "h1" {
+		t.Errorf("PasswordHash(bob) = %q, %v", h, err)
+	}
+	if err := st.SetPasswordHash("bob", "h4"); err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := st.PasswordHash("bob"); h != "h4" {
+		t.Errorf("password not changed: %q", h)
+	}
+	if users, err := st.ListUsers(); err != nil || len(users) != 2 || users[0] != "alice" || users[1] != "bob" {
+		t.Errorf("ListUsers() = %v, %v", users, err)
+	}
+	if err := st.DeleteUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	for name, err := range map[string]error{
+		"PasswordHash":    func() error { _, err := st.PasswordHash("bob"); return err }(),
+		"SetPasswordHash": st.SetPasswordHash("bob", "x"),
+		"DeleteUser":      st.DeleteUser("bob"),
+	} {
+		if !errors.Is(err, store.ErrUserNotFound) {
+			t.Errorf("%s of a deleted user: %v, want ErrUserNotFound", name, err)
+		}
+	}
+}
+
+func testHistoryPages(t *testing.T, st store.Store) {
+	var all []int64
+	for i := 0; i < 5; i++ {
+		from, to := "alice", "bob"
+		if i%2 == 1 {
+			from, to = to, from
+		}
+		all = append(all, send(t, st, from, to, "m").ID)
+	}
+	send(t, st, "alice", "carol", "elsewhere")
+
+	tests := []struct {
+		name    string
+		page    store.Page
+		want    []int64
+		hasMore bool
+	}{
+		{"newest", store.Page{Limit: 2}, all[3:], true},
+		{"back", store.Page{Before: all[3], Limit: 2}, all[1:3], true},
+		{"back to the start", store.Page{Before: all[1], Limit: 2}, all[:1], false},
+		{"forward", store.Page{After: all[0], Limit: 2}, all[1:3], true},
+		{"forward to the end", store.Page{After: all[2], Limit: 10}, all[3:], false},
+		{"everything", store.Page{}, all, false},
+		{"between", store.Page{After: all[0], Before: all[4], Limit: 10}, all[1:4], false},
+	}
+	for _, tt := range tests {
+		msgs, hasMore, err := st.LoadHistory("bob", "alice", tt.page)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !slices.Equal(ids(msgs), tt.want) || hasMore != tt.hasMore {
+			t.Errorf("%s: got %v hasMore %v, want %v hasMore %v", tt.name, ids(msgs), hasMore, tt.want, tt.hasMore)
+		}
+	}
+}
+
+func testSentAt(t *testing.T, st store.Store) {
+	m := send(t, st, "alice", "bob", "hi")
+	if m.SentAt.Location() != time.UTC || !m.SentAt.Equal(m.SentAt.Truncate(time.Second)) {
+		t.Errorf("SentAt %v is not UTC in whole seconds", m.SentAt)
+	}
+	msgs, _, err := st.LoadHistory("alice", "bob", store.Page{})
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("LoadHistory: %v, %v", msgs, err)
+	}
+	if !msgs[0].SentAt.Equal(m.SentAt) {
+		t.Errorf("stored SentAt %v, SaveMessage said %v", msgs[0].SentAt, m.SentAt)
+	}
+}
+
+func testDelivery(t *testing.T, st store.Store) {
+	m1 := send(t, st, "alice", "bob", "one")
+	m2 := send(t, st, "alice", "bob", "two")
+	toAlice := send(t, st, "bob", "alice", "three")
+
+	undelivered, err := st.LoadUndelivered("bob")
+	if err != nil || !slices.Equal(ids(undelivered), []int64{m1.ID, m2.ID}) {
+		t.Fatalf("LoadUndelivered(bob) = %v, %v", ids(undelivered), err)
+	}
+	// loading alone doesn't deliver anything, and bob can't ack alice's mail
+	if err := st.MarkDelivered("bob", []int64{m1.ID, toAlice.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if undelivered, _ := st.LoadUndelivered("bob"); !slices.Equal(ids(undelivered), []int64{m2.ID}) {
+		t.Errorf("after ack of m1: %v", ids(undelivered))
+	}
+	if undelivered, _ := st.LoadUndelivered("alice"); !slices.Equal(ids(undelivered), []int64{toAlice.ID}) {
+		t.Errorf("alice's message was acked by bob: %v", ids(undelivered))
+	}
+	// nor is what bob deleted for himself replayed to him
+	if _, err := st.HideMessage(m2.ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if undelivered, _ := st.LoadUndelivered("bob"); len(undelivered) != 0 {
+		t.Errorf("hidden message still undelivered: %v", ids(undelivered))
+	}
+}
+
+func testClientMsgID(t *testing.T, st store.Store) {
+	first, created, err := st.SaveMessage("alice", "bob", "text", "hi", "c1")
+	if err != nil || !created {
+		t.Fatalf("first send: created %v, %v", created, err)
+	}
+	retry, created, err := st.SaveMessage("alice", "bob", "text", "hi", "c1")
+	if err != nil || created || retry.ID != first.ID || !retry.SentAt.Equal(first.SentAt) {
+		t.Errorf("retry: %+v created %v, %v; want the first message back", retry, created, err)
+	}
+	// the ID is per sender
+	other, created, err := st.SaveMessage("bob", "alice", "text", "hi", "c1")
+	if err != nil || !created || other.ID == first.ID {
+		t.Errorf("bob's c1 was taken for alice's: %+v created %v, %v", other, created, err)
+	}
+	// and without one every send is new
+	a, _, _ := st.SaveMessage("alice", "bob", "text", "again", "")
+	b, created, _ := st.SaveMessage("alice", "bob", "text", "again", "")
+	if !created || a.ID == b.ID {
+		t.Error("sends without a client ID were merged")
+	}
+	if msgs, _, _ := st.LoadHistory("alice", "bob", store.Page{}); len(msgs) != 4 {
+		t.Errorf("history holds %d messages, want 4", len(msgs))
+	}
+}
+
+func testEdit(t *testing.T, st store.Store) {
+	m := send(t, st, "alice", "bob", "helo")
+	file, _, _ := st.SaveMessage("alice", "bob", "file", "/uploads/x.png", "")
+
+	if _, err := st.EditMessage(m.ID, "bob", "x"); !errors.Is(err, store.ErrNotSender) {
+		t.Errorf("edit by recipient: %v, want ErrNotSender", err)
+	}
+	if _, err := st.EditMessage(file.ID, "alice", "x"); !errors.Is(err, store.ErrNotEditable) {
+		t.Errorf("edit of a file: %v, want ErrNotEditable", err)
+	}
+	if _, err := st.EditMessage(9999, "alice", "x"); !errors.Is(err, store.ErrMessageNotFound) {
+		t.Errorf("edit of a missing message: %v, want ErrMessageNotFound", err)
+	}
+	edited, err := st.EditMessage(m.ID, "alice", "hello")
+	if err != nil || edited.Content != "hello" || edited.EditedAt == nil {
+		t.Fatalf("EditMessage = %+v, %v", edited, err)
+	}
+	msgs, _, _ := st.LoadHistory("bob", "alice", store.Page{})
+	if msgs[0].Content != "hello" || msgs[0].EditedAt == nil {
+		t.Errorf("history shows %+v", msgs[0])
+	}
+
+	// with the newest message deleted for everyone the snippet falls back
+	// to the one before, whose edit must then show in the chat list
+	newest := send(t, st, "alice", "bob", "tpyo")
+	gone := send(t, st, "alice", "bob", "oops")
+	if _, _, err := st.DeleteMessage(gone.ID, "alice", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.EditMessage(newest.ID, "alice", "typo"); err != nil {
+		t.Fatal(err)
+	}
+	if chats, _ := st.LoadChats("bob"); len(chats) != 1 || chats[0].LastMessage != "typo" {
+		t.Errorf("snippet after editing the newest message left: %+v", chats)
+	}
+}
+
+func testDelete(t *testing.T, st store.Store) {
+	m1 := send(t, st, "alice", "bob", "one")
+	m2 := send(t, st, "alice", "bob", "two")
+
+	if _, err := st.HideMessage(m1.ID, "carol"); !errors.Is(err, store.ErrMessageNotFound) {
+		t.Errorf("hide by an outsider: %v, want ErrMessageNotFound", err)
+	}
+	if _, err := st.HideMessage(m1.ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _, _ := st.LoadHistory("bob", "alice", store.Page{}); !slices.Equal(ids(msgs), []int64{m2.ID}) {
+		t.Errorf("bob still sees the hidden message: %v", ids(msgs))
+	}
+	if msgs, _, _ := st.LoadHistory("alice", "bob", store.Page{}); len(msgs) != 2 {
+		t.Errorf("hiding took the message from alice too: %v", ids(msgs))
+	}
+
+	if _, _, err := st.DeleteMessage(m2.ID, "bob", 0); !errors.Is(err, store.ErrNotSender) {
+		t.Errorf("delete by recipient: %v, want ErrNotSender", err)
+	}
+	gone, _, err := st.DeleteMessage(m2.ID, "alice", 0)
+	if err != nil || gone.Content != "" || gone.DeletedAt == nil {
+		t.Fatalf("DeleteMessage = %+v, %v", gone, err)
+	}
+	if _, _, err := st.DeleteMessage(m2.ID, "alice", 0); !errors.Is(err, store.ErrMessageDeleted) {
+		t.Errorf("second delete: %v, want ErrMessageDeleted", err)
+	}
+	if _, err := st.EditMessage(m2.ID, "alice", "x"); !errors.Is(err, store.ErrMessageDeleted) {
+		t.Errorf("edit of a tombstone: %v, want ErrMessageDeleted", err)
+	}
+	msgs, _, _ := st.LoadHistory("alice", "bob", store.Page{})
+	if len(msgs) != 2 || msgs[1].Content != "" || msgs[1].DeletedAt == nil {
+		t.Errorf("history has no tombstone: %+v", msgs)
+	}
+	if chats, _ := st.LoadChats("alice"); len(chats) != 1 || chats[0].LastMessage != "one" {
+		t.Errorf("snippet not refreshed: %+v", chats)
+	}
+
+	// a deleted file message takes its upload along once nothing else
+	// links to it
+	if err := st.SaveFile(types.FileRow{ID: "f3", Uploader: "alice", OriginalName: "c.png"}); err != nil {
+		t.Fatal(err)
+	}
+	url := "https://chat.example/uploads/f3.png"
+	f1, _, _ := st.SaveMessage("alice", "bob", "file", url, "")
+	f2, _, _ := st.SaveMessage("alice", "carol", "file", url, "")
+	if _, upload, err := st.DeleteMessage(f1.ID, "alice", 0); err != nil || upload != "" {
+		t.Errorf("DeleteMessage = %q, %v while another message links to f3", upload, err)
+	}
+	if _, upload, err := st.DeleteMessage(f2.ID, "alice", 0); err != nil || upload != "f3.png" {
+		t.Errorf("DeleteMessage = %q, %v, want f3.png", upload, err)
+	}
+	if p, _ := st.PurgeMessages(store.Retention{MaxAge: time.Hour}, time.Now().Add(2*time.Hour), 10); len(p.Files) != 0 {
+		t.Errorf("purge found the deleted upload again: %v", p.Files)
+	}
+}
+
+func testChatsAndReads(t *testing.T, st store.Store) {
+	send(t, st, "alice", "bob", "one")
+	m2 := send(t, st, "alice", "bob", "two")
+	send(t, st, "bob", "alice", "mine")
+	send(t, st, "carol", "bob", "hey")
+
+	unread := func() map[string]int {
+		chats, err := st.LoadChats("bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make(map[string]int)
+		for _, c := range chats {
+			out[c.With] = c.UnreadCount
+		}
+		return out
+	}
+	if u := unread(); u["alice"] != 2 || u["carol"] != 1 {
+		t.Errorf("unread = %v, want alice 2, carol 1", u)
+	}
+
+	if got, err := st.MarkRead("bob", "alice", 0); err != nil || got != m2.ID {
+		t.Errorf("MarkRead = %d, %v, want %d", got, err, m2.ID)
+	}
+	if got, _ := st.MarkRead("bob", "alice", 0); got != 0 {
+		t.Errorf("MarkRead without news moved the cursor to %d", got)
+	}
+	if u := unread(); u["alice"] != 0 || u["carol"] != 1 {
+		t.Errorf("unread after reading alice = %v", u)
+	}
+	// what bob deleted for himself isn't waiting to be read
+	hey, _, _ := st.LoadHistory("bob", "carol", store.Page{})
+	if _, err := st.HideMessage(hey[0].ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if u := unread(); u["carol"] != 0 {
+		t.Errorf("unread after hiding carol's message = %v", u)
+	}
+}
+
+func testDisappearing(t *testing.T, st store.Store) {
+	kept := send(t, st, "alice", "bob", "before")
+	notice, err := st.SetDisappearing("bob", "alice", time.Hour, "bob set messages to disappear after 1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the notice is saved with the timer and expires under it
+	if notice.ContentType != "system" || notice.Sender != "bob" || notice.Recipient != "alice" ||
+		notice.ExpiresAt == nil || !notice.ExpiresAt.Equal(notice.SentAt.Add(time.Hour)) {
+		t.Fatalf("notice = %+v", notice)
+	}
+	if msgs, _, _ := st.LoadHistory("alice", "bob", store.Page{}); !slices.Equal(ids(msgs), []int64{kept.ID, notice.ID}) {
+		t.Errorf("history after setting the timer: %v", ids(msgs))
+	}
+	m := send(t, st, "alice", "bob", "after")
+	if m.ExpiresAt == nil || !m.ExpiresAt.Equal(m.SentAt.Add(time.Hour)) {
+		t.Fatalf("ExpiresAt = %v, want an hour after %v", m.ExpiresAt, m.SentAt)
+	}
+
+	if p, err := st.PurgeExpired(time.Now(), 10); err != nil || len(p.Messages) != 0 {
+		t.Errorf("purged before the timer ran out: %v, %v", ids(p.Messages), err)
+	}
+	p, err := st.PurgeExpired(time.Now().Add(2*time.Hour), 10)
+	if err != nil || !slices.Equal(ids(p.Messages), []int64{notice.ID, m.ID}) {
+		t.Errorf("PurgeExpired = %v, %v", ids(p.Messages), err)
+	}
+	if msgs, _, _ := st.LoadHistory("alice", "bob", store.Page{}); !slices.Equal(ids(msgs), []int64{kept.ID}) {
+		t.Errorf("history after expiry: %v", ids(msgs))
+	}
+	chats, _ := st.LoadChats("alice")
+	if len(chats) != 1 || chats[0].DisappearAfter != 3600 || chats[0].LastMessage != "before" {
+		t.Errorf("chats = %+v", chats)
+	}
+}
+
+func testRetention(t *testing.T, st store.Store) {
+	if err := st.SaveFile(types.FileRow{ID: "f1", Uploader: "alice", OriginalName: "a.png"}); err != nil {
+		t.Fatal(err)
+	}
+	file, _, _ := st.SaveMessage("alice", "bob", "file", "https://chat.example/uploads/f1.png", "")
+	text := send(t, st, "alice", "bob", "hi")
+
+	policy := store.Retention{ContentTypes: map[string]time.Duration{"file": time.Hour}}
+	p, err := st.PurgeMessages(policy, time.Now().Add(2*time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids(p.Messages), []int64{file.ID}) || p.ByType["file"] != 1 {
+		t.Errorf("purged %v %v, want only the file message", ids(p.Messages), p.ByType)
+	}
+	if len(p.Files) != 1 || p.Files[0] != "f1.png" {
+		t.Errorf("Files = %v, want [f1.png]", p.Files)
+	}
+
+	p, err = st.PurgeMessages(store.Retention{MaxAge: time.Hour}, time.Now().Add(2*time.Hour), 10)
+	if err != nil || !slices.Equal(ids(p.Messages), []int64{text.ID}) || p.Conversations != 1 {
+		t.Errorf("PurgeMessages = %v, %d conversations, %v", ids(p.Messages), p.Conversations, err)
+	}
+	if chats, _ := st.LoadChats("alice"); len(chats) != 0 {
+		t.Errorf("empty conversation kept: %+v", chats)
+	}
+
+	// an upload sent twice stays until the second message goes
+	if err := st.SaveFile(types.FileRow{ID: "f2", Uploader: "alice", OriginalName: "b.png"}); err != nil {
+		t.Fatal(err)
+	}
+	st.SaveMessage("alice", "bob", "file", "https://chat.example/uploads/f2.png", "")
+	st.SaveMessage("alice", "carol", "file", "https://chat.example/uploads/f2.png", "")
+	if p, err := st.PurgeMessages(policy, time.Now().Add(2*time.Hour), 1); err != nil || len(p.Files) != 0 {
+		t.Errorf("Files = %v, %v while a message still links to f2", p.Files, err)
+	}
+	if p, err := st.PurgeMessages(policy, time.Now().Add(2*time.Hour), 1); err != nil || !slices.Equal(p.Files, []string{"f2.png"}) {
+		t.Errorf("Files = %v, %v, want [f2.png]", p.Files, err)
+	}
+}
+
+func testDevices(t *testing.T, st store.Store) {
+	old := send(t, st, "alice", "bob", "before any device")
+	if err := st.MarkDelivered("bob", []int64{old.ID}); err != nil {
+		t.Fatal(err)
+	}
+	pending := send(t, st, "alice", "bob", "not acked yet")
+
+	// a new device gets what no device has acked, but no older history
+	phone, err := st.RegisterDevice("bob", "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _ := st.LoadSince("bob", "phone", phone); !slices.Equal(ids(msgs), []int64{pending.ID}) {
+		t.Errorf("new device gets %v, want %v", ids(msgs), pending.ID)
+	}
+	laptop, _ := st.RegisterDevice("bob", "laptop")
+
+	m := send(t, st, "alice", "bob", "new")
+	send(t, st, "bob", "alice", "bob's own")
+	if err := st.MarkSynced("bob", "phone", []int64{pending.ID, m.ID}); err != nil {
+		t.Fatal(err)
+	}
+	phone, _ = st.RegisterDevice("bob", "phone")
+	if msgs, _ := st.LoadSince("bob", "phone", phone); len(msgs) != 0 {
+		t.Errorf("phone acked everything but still gets %v", ids(msgs))
+	}
+	// the laptop's cursor is its own
+	if again, _ := st.RegisterDevice("bob", "laptop"); again != laptop {
+		t.Errorf("laptop cursor moved from %d to %d", laptop, again)
+	}
+	if msgs, _ := st.LoadSince("bob", "laptop", laptop); !slices.Equal(ids(msgs), []int64{pending.ID, m.ID}) {
+		t.Errorf("laptop gets %v", ids(msgs))
+	}
+}
+
+func testAcksOutOfOrder(t *testing.T, st store.Store) {
+	synced, err := st.RegisterDevice("bob", "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1 := send(t, st, "alice", "bob", "one")
+	m2 := send(t, st, "alice", "bob", "two")
+	m3 := send(t, st, "alice", "bob", "three")
+
+	// the ack for m1 is late or lost: m1 must still be replayed
+	if err := st.MarkSynced("bob", "phone", []int64{m2.ID, m3.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if synced, _ = st.RegisterDevice("bob", "phone"); synced >= m1.ID {
+		t.Errorf("cursor skipped to %d over the unacked %d", synced, m1.ID)
+	}
+	if msgs, _ := st.LoadSince("bob", "phone", synced); !slices.Equal(ids(msgs), []int64{m1.ID}) {
+		t.Errorf("replay = %v, want only %d", ids(msgs), m1.ID)
+	}
+
+	// once it comes the cursor passes all three
+	if err := st.MarkSynced("bob", "phone", []int64{m1.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.RegisterDevice("bob", "phone"); got != m3.ID {
+		t.Errorf("cursor = %d, want %d", got, m3.ID)
+	}
+
+	// a message deleted before its ack doesn't hold the cursor back
+	m4 := send(t, st, "alice", "bob", "four")
+	m5 := send(t, st, "alice", "bob", "five")
+	if _, _, err := st.DeleteMessage(m4.ID, "alice", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.MarkSynced("bob", "phone", []int64{m5.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.RegisterDevice("bob", "phone"); got != m5.ID {
+		t.Errorf("cursor = %d, want %d", got, m5.ID)
+	}
+}
+
+func testSearch(t *testing.T, st store.Store) {
+	lunch := send(t, st, "alice", "bob", "Lunch tomorrow?")
+	send(t, st, "bob", "alice", "sure, where")
+	send(t, st, "alice", "carol", "lunch with bob")
+
+	msgs, _, err := st.SearchMessages("bob", store.SearchQuery{Text: "lun tomorrow"})
+	if err != nil || !slices.Equal(ids(msgs), []int64{lunch.ID}) {
+		t.Errorf("search as bob = %v, %v", ids(msgs), err)
+	}
+	msgs, _, err = st.SearchMessages("alice", store.SearchQuery{Text: "lunch"})
+	if err != nil || len(msgs) != 2 || msgs[0].ID < msgs[1].ID {
+		t.Errorf("search as alice = %v, %v, want both lunch messages newest first", ids(msgs), err)
+	}
+	msgs, _, _ = st.SearchMessages("alice", store.SearchQuery{Text: "lunch", ChatWith: "carol"})
+	if len(msgs) != 1 || msgs[0].Recipient != "carol" {
+		t.Errorf("chatWith filter: %v", ids(msgs))
+	}
+}
+
+// TestFileIDBackfill checks that the migration adding file_id fills it in
+// for existing file messages exactly as SaveMessage does for new ones.
+func TestFileIDBackfill(t *testing.T) {
+	const id = "0f8fad5b-d9cb-469f-a165-70867728950e"
+	cases := []struct{ content, want string }{
+		{"https://chat.example/uploads/" + id + ".png", id},
+		{"https://chat.example/uploads/" + id, id},
+		{"https://chat.example/uploads/" + id + ".tar.gz", id},
+		{"https://chat.example/uploads/short.png", "short"},
+		// the last /uploads/ counts
+		{"https://chat.example/uploads/old/uploads/" + id + ".jpg", id},
+		{"https://chat.example/uploads/" + id + "/x.png", ""},
+		{"https://chat.example/uploads/", ""},
+		{"https://chat.example/uploads/.hidden", ""},
+		{`https://chat.example/uploads/a\b.png`, ""},
+		{"https://chat.example/uploads/" + id + "-too-long.png", ""},
+		{"https://chat.example/UPLOADS/" + id + ".png", ""},
+		{"https://chat.example/files/" + id + ".png", ""},
+	}
+
+	database, err := db.Connect("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewSQLite(database)
+	t.Cleanup(func() { st.Close() })
+	all, err := migrate.Bundled("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrate.Up(database, "sqlite"); err != nil {
+		t.Fatal(err)
+	}
+	// step back to just before file_id existed, leave some file messages
+	// behind and come up again
+	i := slices.IndexFunc(all, func(m migrate.Migration) bool { return m.Name == "message_files" })
+	if i < 0 {
+		t.Fatal("no message_files migration")
+	}
+	if _, err := migrate.Down(database, "sqlite", len(all)-i); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		if _, err := database.Exec("INSERT INTO messages (sender, recipient, content_type, content) VALUES ('alice', 'bob', 'file', ?)",
+			c.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := migrate.Up(database, "sqlite"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		if _, _, err := st.SaveMessage("alice", "bob", "file", c.content, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := database.Query("SELECT file_id FROM messages ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var fileID sql.NullString
+		if err := rows.Scan(&fileID); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fileID.String)
+	}
+	if err := rows.Err(); err != nil || len(got) != 2*len(cases) {
+		t.Fatalf("read %d file IDs, %v", len(got), err)
+	}
+	for i, c := range cases {
+		if backfilled, saved := got[i], got[len(cases)+i]; backfilled != c.want || saved != c.want {
+			t.Errorf("%q: backfill gives %q, SaveMessage %q, want %q", c.content, backfilled, saved, c.want)
+		}
+	}
+}
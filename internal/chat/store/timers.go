@@ -0,0 +1,56 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/types"
+)
+
+func (s *SQL) SetDisappearing(user, withUser string, d time.Duration, notice string) (types.MessageRow, error) {
+	defer queryDuration.Since("SetDisappearing", time.Now())
+	tx, err := s.db.Begin()
+	if err != nil {
+		return types.MessageRow{}, fmt.Errorf("set disappearing timer: %w", err)
+	}
+	defer tx.Rollback()
+	q := s.dialect.rebind
+
+	now := now()
+	user1, user2 := sortTwoUsers(user, withUser)
+	secs := int64(d / time.Second)
+	// the upsert makes sure the conversation row exists for the update
+	if _, err := tx.Exec(q(s.dialect.upsertConversation), user1, user2, notice, now); err != nil {
+		return types.MessageRow{}, fmt.Errorf("set disappearing timer: %w", err)
+	}
+	if _, err := tx.Exec(q("UPDATE conversations SET disappear_after = ? WHERE user1 = ? AND user2 = ?"),
+		secs, user1, user2); err != nil {
+		return types.MessageRow{}, fmt.Errorf("set disappearing timer: %w", err)
+	}
+
+	m := types.MessageRow{
+		Sender:      user,
+		Recipient:   withUser,
+		ContentType: "system",
+		Content:     notice,
+		SentAt:      now,
+	}
+	var expiresAt sql.NullTime
+	if secs > 0 {
+		expiresAt = sql.NullTime{Time: now.Add(time.Duration(secs) * time.Second), Valid: true}
+		m.ExpiresAt = &expiresAt.Time
+	}
+	m.ID, err = s.insertID(tx, `
+		INSERT INTO messages (sender, recipient, content_type, content, sent_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		user, withUser, m.ContentType, notice, now, expiresAt,
+	)
+	if err != nil {
+		return types.MessageRow{}, fmt.Errorf("save timer notice: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return types.MessageRow{}, fmt.Errorf("set disappearing timer: %w", err)
+	}
+	return m, nil
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *SQL) CreateUser(username, passwordHash string) error {
+	defer queryDuration.Since("CreateUser", time.Now())
+	query := "INSERT INTO users (username, password_hash) VALUES (?, ?)"
+	if _, err := s.exec(query, username, passwordHash); err != nil {
+		if s.dialect.isDuplicate(err) {
+			return ErrUserExists
+		}
+		return fmt.Errorf("insert user: %w", err)
+	}
+	return nil
+}
+
+func (s *SQL) PasswordHash(username string) (string, error) {
+	defer queryDuration.Since("PasswordHash", time.Now())
+	var hash string
+	query := "SELECT password_hash FROM users WHERE username = ?"
+	if err := s.queryRow(query, username).Scan(&hash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrUserNotFound
+		}
+		return "", fmt.Errorf("query user: %w", err)
+	}
+	return hash, nil
+}
+
+func (s *SQL) SetPasswordHash(username, passwordHash string) error {
+	res, err := s.exec("UPDATE users SET password_hash = ? WHERE username = ?", passwordHash, username)
+	if err != nil {
+		return fmt.Errorf("update user: %w", err)
+	}
+	return expectOneRow(res)
+}
+
+// DeleteUser removes a user record. Their messages are left in place.
+func (s *SQL) DeleteUser(username string) error {
+	res, err := s.exec("DELETE FROM users WHERE username = ?", username)
+	if err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	return expectOneRow(res)
+}
+
+// ListUsers returns every username in alphabetical order.
+func (s *SQL) ListUsers() ([]string, error) {
+	rows, err := s.query("SELECT username FROM users ORDER BY username")
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	defer rows.Close()
+	var users []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func expectOneRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
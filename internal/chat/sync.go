@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"fmt"
+
+	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/types"
+)
+
+// maxDeviceID is the longest device ID devices.device_id holds.
+const maxDeviceID = 64
+
+// connectDevice registers ci as one of user's live connections and sends
+// it what it missed: everything past its sync cursor it hasn't acked for a
+// client that named its device, what no device has acked yet otherwise.
+// Frames sent to the socket meanwhile are held back until the replay is
+// out, and since what it missed is read only once the connection is live,
+// a message saved in between is in the replay, the held frames or both,
+// in which case it goes out once.
+func connectDevice(messages store.MessageStore, user string, ci types.ConnectionInfo) error {
+	hold(ci.Conn)
+	addConnection(user, ci)
+
+	var replay []types.IncomingMessage
+	defer func() { release(ci.Conn, replay) }()
+
+	var missed []types.MessageRow
+	if ci.Device == "" {
+		var err error
+		if missed, err = messages.LoadUndelivered(user); err != nil {
+			return fmt.Errorf("load undelivered: %w", err)
+		}
+	} else {
+		synced, err := messages.RegisterDevice(user, ci.Device)
+		if err != nil {
+			return err
+		}
+		if missed, err = messages.LoadSince(user, ci.Device, synced); err != nil {
+			return err
+		}
+	}
+	for _, row := range missed {
+		replay = append(replay, messageFrame(row))
+	}
+	return nil
+}
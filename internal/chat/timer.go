@@ -0,0 +1,105 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/auth"
+	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxTimer caps the disappearing timer; anything longer is what retention
+// is for.
+const maxTimer = 365 * 24 * time.Hour
+
+// handleTimer answers a "setTimer" frame: either participant sets the
+// conversation's disappearing timer, and both sides get a "system"
+// message saying so.
+func handleTimer(conn *websocket.Conn, st store.Store, rawMsg []byte, flog *slog.Logger) {
+	var req types.TimerRequest
+	if err := json.Unmarshal(rawMsg, &req); err != nil {
+		sendError(conn, "setTimer", "bad timer request")
+		return
+	}
+	flog.Debug("frame received", "req", req)
+	user, err := auth.ParseToken(req.Token)
+	if err != nil {
+		sendError(conn, "setTimer", "invalid token")
+		return
+	}
+	d := time.Duration(req.Seconds) * time.Second
+	if req.Seconds < 0 || d > maxTimer {
+		sendError(conn, "setTimer", fmt.Sprintf("seconds must be between 0 and %d", int64(maxTimer/time.Second)))
+		return
+	}
+	exists, err := auth.Exists(st, req.ChatWith)
+	if err != nil {
+		flog.Error("look up user", "chatWith", req.ChatWith, "err", err)
+		sendError(conn, "setTimer", "internal error")
+		return
+	}
+	if !exists {
+		sendError(conn, "setTimer", store.ErrUserNotFound.Error())
+		return
+	}
+
+	// the notice goes in with the timer, so it expires after d like
+	// everything sent from now on
+	text := user + " turned off disappearing messages"
+	if d > 0 {
+		text = fmt.Sprintf("%s set messages to disappear after %s", user, shortDuration(d))
+	}
+	m, err := st.SetDisappearing(user, req.ChatWith, d, text)
+	if err != nil {
+		flog.Error("set disappearing timer", "chatWith", req.ChatWith, "err", err)
+		sendError(conn, "setTimer", "internal error")
+		return
+	}
+	flog.Info("disappearing timer set", "chatWith", req.ChatWith, "after", d)
+	notifyParticipants(user, req.ChatWith, messageFrame(m))
+}
+
+// NotifyExpired tells the live connections of both participants which of
+// their messages disappeared.
+func NotifyExpired(msgs []types.MessageRow) {
+	// user -> peer -> IDs
+	byUser := make(map[string]map[string][]int64)
+	add := func(user, peer string, id int64) {
+		if byUser[user] == nil {
+			byUser[user] = make(map[string][]int64)
+		}
+		byUser[user][peer] = append(byUser[user][peer], id)
+	}
+	for _, m := range msgs {
+		add(m.Sender, m.Recipient, m.ID)
+		if m.Recipient != m.Sender {
+			add(m.Recipient, m.Sender, m.ID)
+		}
+	}
+	for user, peers := range byUser {
+		conns := connectionsFor(user)
+		for peer, ids := range peers {
+			for _, ci := range conns {
+				send(ci.Conn, types.MessagesExpired{Type: "expired", ChatWith: peer, IDs: ids})
+			}
+		}
+	}
+}
+
+// shortDuration prints d without trailing zero units: "24h", "1h30m", "45s".
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
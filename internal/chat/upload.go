@@ -1,54 +1,36 @@
 package chat
 
 import (
-	"database/sql"
 	"encoding/json"
-	"fmt"
 	"io"
-	"github.com/jad0s/libretalk/internal/auth"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
+
+	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/config"
+	"github.com/jad0s/libretalk/internal/proxy"
+	"github.com/jad0s/libretalk/internal/types"
 
 	"github.com/google/uuid"
 )
 
 // uploadHandler handles multipart uploads under the field name "file"
-// and writes metadata into "files" table in db
-func UploadHandler(db *sql.DB) http.HandlerFunc {
+// and records their metadata in files
+func UploadHandler(files store.FileStore, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// 1) Limit size (optional)
-		r.Body = http.MaxBytesReader(w, r.Body, 50<<20) // 50 MiB max
+		// 1) Limit size
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxUploadBytes)
 
 		// authenticate user
-
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "missing authorization", http.StatusUnauthorized)
-			return
-		}
-
-		if !strings.HasPrefix(strings.ToLower(authHeader), "bearer ") {
-			http.Error(w, "bad authorization scheme", http.StatusUnauthorized)
-			return
-		}
-
-		parts := strings.Fields(authHeader)
-		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
-			http.Error(w, "bad authorization header", http.StatusUnauthorized)
-			return
-		}
-		tokenStr := parts[1]
-
-		username, err := auth.ParseToken(tokenStr)
-		if err != nil {
-			http.Error(w, "invalid token", http.StatusUnauthorized)
+		username, ok := bearerUser(w, r)
+		if !ok {
 			return
 		}
 
 		// 2) Parse the multipart form
-		if err := r.ParseMultipartForm(50 << 20); err != nil {
+		if err := r.ParseMultipartForm(cfg.MaxUploadBytes); err != nil {
 			http.Error(w, "file too large", http.StatusBadRequest)
 			return
 		}
@@ -65,20 +47,20 @@ func UploadHandler(db *sql.DB) http.HandlerFunc {
 		id := uuid.New().String()
 		ext := filepath.Ext(header.Filename)
 		savedName := id + ext
-		uploadDir := "./uploads"
+		uploadDir := cfg.UploadDir
 		outPath := filepath.Join(uploadDir, savedName)
 
 		// ensure uploadDir exists
 		if err := os.MkdirAll(uploadDir, 0755); err != nil {
 			http.Error(w, "server error", http.StatusInternalServerError)
-			fmt.Println("mkdir error:", err)
+			slog.Error("upload: create upload dir", "dir", uploadDir, "err", err)
 			return
 		}
 
 		out, err := os.Create(outPath)
 		if err != nil {
 			http.Error(w, "server error", http.StatusInternalServerError)
-			fmt.Println("create file error:", err)
+			slog.Error("upload: create file", "user", username, "path", outPath, "err", err)
 			return
 		}
 		defer out.Close()
@@ -86,24 +68,29 @@ func UploadHandler(db *sql.DB) http.HandlerFunc {
 		size, err := io.Copy(out, file)
 		if err != nil {
 			http.Error(w, "server error", http.StatusInternalServerError)
-			fmt.Println("copy file error:", err)
+			slog.Error("upload: write file", "user", username, "path", outPath, "err", err)
 			return
 		}
 
 		// 5) Insert metadata into DB
 
-		_, err = db.Exec(`
-            INSERT INTO files (id, uploader, original_name, content_type, size_bytes)
-            VALUES (?, ?, ?, ?, ?)`,
-			id, username, header.Filename, header.Header.Get("Content-Type"), size,
-		)
-
+		err = files.SaveFile(types.FileRow{
+			ID:           id,
+			Uploader:     username,
+			OriginalName: header.Filename,
+			ContentType:  header.Header.Get("Content-Type"),
+			SizeBytes:    size,
+		})
 		if err != nil {
 			http.Error(w, "server error", http.StatusInternalServerError)
-			fmt.Println("db insert error:", err)
+			slog.Error("upload: insert metadata", "user", username, "id", id, "err", err)
 			return
 		}
 
+		slog.Info("file uploaded", "user", username, "ip", proxy.ClientIP(r), "id", id, "size", size)
+		uploadsStored.Inc("")
+		uploadBytes.Add("", float64(size))
+
 		// 6) Return JSON with the file URL
 		resp := map[string]interface{}{
 			"id":          id,
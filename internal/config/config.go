@@ -0,0 +1,401 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every server setting. It is loaded once at startup and
+// handed to the packages that need it.
+type Config struct {
+	// Listen is a TCP address (":8081"), "unix:/path/to.sock", or
+	// "systemd" / "systemd:<name>" to take a socket opened by systemd.
+	Listen         string   `json:"listen" yaml:"listen" toml:"listen"`
+	SocketMode     string   `json:"socketMode" yaml:"socketMode" toml:"socket_mode"` // octal permissions of a unix: socket
+	UploadDir      string   `json:"uploadDir" yaml:"uploadDir" toml:"upload_dir"`
+	MaxUploadBytes int64    `json:"maxUploadBytes" yaml:"maxUploadBytes" toml:"max_upload_bytes"`
+	AllowedOrigins []string `json:"allowedOrigins" yaml:"allowedOrigins" toml:"allowed_origins"` // empty = allow any origin
+	// ShutdownTimeout bounds how long open sockets get to finish their
+	// current frame after SIGINT/SIGTERM before they are cut off.
+	ShutdownTimeout Duration  `json:"shutdownTimeout" yaml:"shutdownTimeout" toml:"shutdown_timeout"`
+	Log             Log       `json:"log" yaml:"log" toml:"log"`
+	Proxy           Proxy     `json:"proxy" yaml:"proxy" toml:"proxy"`
+	TLS             TLS       `json:"tls" yaml:"tls" toml:"tls"`
+	Database        Database  `json:"database" yaml:"database" toml:"database"`
+	Messages        Messages  `json:"messages" yaml:"messages" toml:"messages"`
+	Retention       Retention `json:"retention" yaml:"retention" toml:"retention"`
+	Auth            Auth      `json:"auth" yaml:"auth" toml:"auth"`
+}
+
+// Log selects the log level (debug, info, warn, error) and output format
+// (text or json).
+type Log struct {
+	Level  string `json:"level" yaml:"level" toml:"level"`
+	Format string `json:"format" yaml:"format" toml:"format"`
+}
+
+// Proxy describes the reverse proxies in front of the server. Requests
+// from a trusted peer may name the real client in X-Forwarded-For or, with
+// Protocol on, in a PROXY protocol (v1/v2) header.
+type Proxy struct {
+	Trusted  []string `json:"trusted" yaml:"trusted" toml:"trusted"` // CIDRs or addresses, e.g. "127.0.0.1/32"
+	Protocol bool     `json:"protocol" yaml:"protocol" toml:"protocol"`
+}
+
+// TLS enables https/wss when both files are set. The pair is re-read
+// whenever it changes on disk or the process gets SIGHUP.
+type TLS struct {
+	CertFile      string   `json:"certFile" yaml:"certFile" toml:"cert_file"`
+	KeyFile       string   `json:"keyFile" yaml:"keyFile" toml:"key_file"`
+	WatchInterval Duration `json:"watchInterval" yaml:"watchInterval" toml:"watch_interval"` // how often to check the files for changes
+}
+
+// Enabled reports whether the server should speak TLS.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// Database selects the storage backend and describes how to reach it.
+// For MySQL and PostgreSQL either DSN is given whole, or it is assembled
+// from the individual fields. SQLite only needs Path.
+type Database struct {
+	// Driver is "mysql", "postgres", "sqlite" or "memory". A DSN starting
+	// with postgres:// or postgresql:// selects "postgres" by itself. The
+	// memory store keeps nothing across restarts and is meant for tests
+	// and trying the server out.
+	Driver       string `json:"driver" yaml:"driver" toml:"driver"`
+	Path         string `json:"path" yaml:"path" toml:"path"` // SQLite database file
+	DSN          string `json:"dsn" yaml:"dsn" toml:"dsn"`
+	DSNFile      string `json:"dsnFile" yaml:"dsnFile" toml:"dsn_file"`
+	Host         string `json:"host" yaml:"host" toml:"host"`
+	Name         string `json:"name" yaml:"name" toml:"name"`
+	User         string `json:"user" yaml:"user" toml:"user"`
+	Password     string `json:"password" yaml:"password" toml:"password"`
+	PasswordFile string `json:"passwordFile" yaml:"passwordFile" toml:"password_file"`
+	Params       string `json:"params" yaml:"params" toml:"params"` // extra DSN parameters, e.g. "tls=true"
+	// AutoMigrate applies pending schema migrations when the server starts.
+	// With it off, serve refuses to start until `libretalk migrate up` is run.
+	AutoMigrate bool `json:"autoMigrate" yaml:"autoMigrate" toml:"auto_migrate"`
+}
+
+// Messages holds rules for what users may do with sent messages.
+type Messages struct {
+	// DeleteWindow limits how long after sending a message its sender
+	// may still delete it for everyone. Zero means no limit.
+	DeleteWindow Duration `json:"deleteWindow" yaml:"deleteWindow" toml:"delete_window"`
+	// ExpiryInterval is how often messages whose disappearing timer ran
+	// out are looked for, i.e. how late they may go.
+	ExpiryInterval Duration `json:"expiryInterval" yaml:"expiryInterval" toml:"expiry_interval"`
+}
+
+// Retention has old messages purged in the background. Every age counts
+// from when a message was sent and zero means no limit; a message goes as
+// soon as any limit that applies to it has passed. With every age zero
+// nothing is purged.
+type Retention struct {
+	Interval          Duration            `json:"interval" yaml:"interval" toml:"interval"`     // how often the job runs
+	BatchSize         int                 `json:"batchSize" yaml:"batchSize" toml:"batch_size"` // messages deleted per transaction
+	MaxAge            Duration            `json:"maxAge" yaml:"maxAge" toml:"max_age"`
+	DeliveredMaxAge   Duration            `json:"deliveredMaxAge" yaml:"deliveredMaxAge" toml:"delivered_max_age"`
+	UndeliveredMaxAge Duration            `json:"undeliveredMaxAge" yaml:"undeliveredMaxAge" toml:"undelivered_max_age"`
+	ContentTypes      map[string]Duration `json:"contentTypes" yaml:"contentTypes" toml:"content_types"` // max age by content type, e.g. "file"
+}
+
+// Enabled reports whether any retention limit is set.
+func (r Retention) Enabled() bool {
+	if r.MaxAge.Duration > 0 || r.DeliveredMaxAge.Duration > 0 || r.UndeliveredMaxAge.Duration > 0 {
+		return true
+	}
+	for _, d := range r.ContentTypes {
+		if d.Duration > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Auth holds the JWT settings.
+type Auth struct {
+	JWTSecret string   `json:"jwtSecret" yaml:"jwtSecret" toml:"jwt_secret"` // base64-encoded HMAC key
+	TokenTTL  Duration `json:"tokenTTL" yaml:"tokenTTL" toml:"token_ttl"`
+}
+
+// Duration is a time.Duration that reads as "24h", "90m", ... from config files.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Default returns the settings used when nothing else is configured.
+func Default() *Config {
+	return &Config{
+		Listen:          ":8081",
+		SocketMode:      "0660",
+		UploadDir:       "./uploads",
+		MaxUploadBytes:  50 << 20, // 50 MiB
+		ShutdownTimeout: Duration{10 * time.Second},
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+		},
+		TLS: TLS{
+			WatchInterval: Duration{30 * time.Second},
+		},
+		Database: Database{
+			Driver:      "mysql",
+			Path:        "libretalk.db",
+			Host:        "localhost:3306",
+			Name:        "libretalk",
+			User:        "libretalk",
+			AutoMigrate: true,
+		},
+		Messages: Messages{
+			ExpiryInterval: Duration{5 * time.Second},
+		},
+		Retention: Retention{
+			Interval:  Duration{time.Hour},
+			BatchSize: 500,
+		},
+		Auth: Auth{
+			TokenTTL: Duration{24 * time.Hour},
+		},
+	}
+}
+
+// Load builds the config from defaults, then the file at path (if any),
+// then LIBRETALK_* environment variables, and validates the result.
+// The file format is picked from the extension: .toml, .json or .yaml
+// (.yml); YAML keys are spelled as in JSON.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Database.loadSecrets(); err != nil {
+		return nil, err
+	}
+	cfg.Database.detectDriver()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+		if undec := md.Undecoded(); len(undec) > 0 {
+			return fmt.Errorf("parse %s: unknown key %q", path, undec[0].String())
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("config %s: unsupported format (use .toml, .json or .yaml)", path)
+	}
+	return nil
+}
+
+// Validate reports every problem with the config at once. auth.jwtSecret
+// is left to the commands that sign or check tokens (see Auth.Secret), so
+// e.g. migrations run without one.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Listen == "" {
+		errs = append(errs, errors.New("listen: must not be empty"))
+	}
+	if _, err := c.SocketFileMode(); err != nil {
+		errs = append(errs, fmt.Errorf("socketMode: %w", err))
+	}
+	if c.UploadDir == "" {
+		errs = append(errs, errors.New("uploadDir: must not be empty"))
+	}
+	if c.MaxUploadBytes <= 0 {
+		errs = append(errs, errors.New("maxUploadBytes: must be positive"))
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("shutdownTimeout: must be positive"))
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log.level: %q is not one of debug, info, warn, error", c.Log.Level))
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log.format: %q is not one of text, json", c.Log.Format))
+	}
+	for _, cidr := range c.Proxy.Trusted {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			if _, err := netip.ParseAddr(cidr); err != nil {
+				errs = append(errs, fmt.Errorf("proxy.trusted: %q is not a CIDR or address", cidr))
+			}
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls: certFile and keyFile must be set together"))
+	}
+	if c.TLS.Enabled() && c.TLS.WatchInterval.Duration <= 0 {
+		errs = append(errs, errors.New("tls.watchInterval: must be positive"))
+	}
+	switch c.Database.Driver {
+	case "mysql", "postgres", "sqlite", "memory":
+	default:
+		errs = append(errs, fmt.Errorf("database.driver: %q is not one of mysql, postgres, sqlite, memory", c.Database.Driver))
+	}
+	if c.Database.Driver == "sqlite" && c.Database.DSN == "" && c.Database.Path == "" {
+		errs = append(errs, errors.New("database.path: must not be empty"))
+	}
+	if (c.Database.Driver == "mysql" || c.Database.Driver == "postgres") && c.Database.DSN == "" {
+		if c.Database.Host == "" {
+			errs = append(errs, errors.New("database.host: must not be empty"))
+		}
+		if c.Database.Name == "" {
+			errs = append(errs, errors.New("database.name: must not be empty"))
+		}
+		if c.Database.User == "" {
+			errs = append(errs, errors.New("database.user: must not be empty"))
+		}
+	}
+	if c.Messages.DeleteWindow.Duration < 0 {
+		errs = append(errs, errors.New("messages.deleteWindow: must not be negative"))
+	}
+	if c.Messages.ExpiryInterval.Duration <= 0 {
+		errs = append(errs, errors.New("messages.expiryInterval: must be positive"))
+	}
+	if c.Retention.Interval.Duration <= 0 {
+		errs = append(errs, errors.New("retention.interval: must be positive"))
+	}
+	if c.Retention.BatchSize <= 0 {
+		errs = append(errs, errors.New("retention.batchSize: must be positive"))
+	}
+	if c.Retention.MaxAge.Duration < 0 || c.Retention.DeliveredMaxAge.Duration < 0 ||
+		c.Retention.UndeliveredMaxAge.Duration < 0 {
+		errs = append(errs, errors.New("retention: ages must not be negative"))
+	}
+	for ct, d := range c.Retention.ContentTypes {
+		if d.Duration < 0 {
+			errs = append(errs, fmt.Errorf("retention.contentTypes: %q must not be negative", ct))
+		}
+	}
+	if c.Auth.TokenTTL.Duration <= 0 {
+		errs = append(errs, errors.New("auth.tokenTTL: must be positive"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// SocketFileMode parses SocketMode as octal permission bits.
+func (c *Config) SocketFileMode() (fs.FileMode, error) {
+	m, err := strconv.ParseUint(c.SocketMode, 8, 32)
+	if err != nil || m > 0o777 {
+		return 0, fmt.Errorf("%q is not an octal mode like 0660", c.SocketMode)
+	}
+	return fs.FileMode(m), nil
+}
+
+// Secret decodes the base64 JWT key. Keys shorter than 32 bytes are rejected.
+func (a Auth) Secret() ([]byte, error) {
+	if a.JWTSecret == "" {
+		return nil, errors.New("must be set (e.g. LIBRETALK_JWT_SECRET=$(openssl rand -base64 32))")
+	}
+	key, err := base64.StdEncoding.DecodeString(a.JWTSecret)
+	if err != nil {
+		return nil, fmt.Errorf("not valid base64: %w", err)
+	}
+	if len(key) < 32 {
+		return nil, fmt.Errorf("must decode to at least 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// HasCredentials reports whether a password or a full DSN was configured,
+// i.e. whether the server can connect without prompting. SQLite needs none.
+func (d Database) HasCredentials() bool {
+	return d.Driver == "sqlite" || d.DSN != "" || d.Password != ""
+}
+
+// detectDriver switches to postgres when the DSN is a postgres:// URL.
+func (d *Database) detectDriver() {
+	if strings.HasPrefix(d.DSN, "postgres://") || strings.HasPrefix(d.DSN, "postgresql://") {
+		d.Driver = "postgres"
+	}
+}
+
+// ConnString returns the connection string for the configured driver:
+// a go-sql-driver/mysql DSN, a postgres:// URL or the SQLite file path.
+func (d Database) ConnString() string {
+	if d.DSN != "" {
+		return d.DSN
+	}
+	switch d.Driver {
+	case "sqlite":
+		return d.Path
+	case "postgres":
+		u := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(d.User, d.Password),
+			Host:     d.Host,
+			Path:     "/" + d.Name,
+			RawQuery: d.Params,
+		}
+		return u.String()
+	}
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s", d.User, d.Password, d.Host, d.Name)
+	if d.Params != "" {
+		dsn += "?" + d.Params
+	}
+	return dsn
+}
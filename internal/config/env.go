@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envPrefix namespaces every environment override.
+const envPrefix = "LIBRETALK_"
+
+// envVar binds one LIBRETALK_* variable to a config field.
+type envVar struct {
+	name string
+	set  func(c *Config, v string) error
+}
+
+var envVars = []envVar{
+	{"LISTEN", func(c *Config, v string) error { c.Listen = v; return nil }},
+	{"SOCKET_MODE", func(c *Config, v string) error { c.SocketMode = v; return nil }},
+	{"UPLOAD_DIR", func(c *Config, v string) error { c.UploadDir = v; return nil }},
+	{"MAX_UPLOAD_BYTES", func(c *Config, v string) error { return setInt64(&c.MaxUploadBytes, v) }},
+	{"ALLOWED_ORIGINS", func(c *Config, v string) error { c.AllowedOrigins = splitList(v); return nil }},
+	{"SHUTDOWN_TIMEOUT", func(c *Config, v string) error { return c.ShutdownTimeout.UnmarshalText([]byte(v)) }},
+	{"LOG_LEVEL", func(c *Config, v string) error { c.Log.Level = v; return nil }},
+	{"LOG_FORMAT", func(c *Config, v string) error { c.Log.Format = v; return nil }},
+	{"TRUSTED_PROXIES", func(c *Config, v string) error { c.Proxy.Trusted = splitList(v); return nil }},
+	{"PROXY_PROTOCOL", func(c *Config, v string) error { return setBool(&c.Proxy.Protocol, v) }},
+	{"TLS_CERT_FILE", func(c *Config, v string) error { c.TLS.CertFile = v; return nil }},
+	{"TLS_KEY_FILE", func(c *Config, v string) error { c.TLS.KeyFile = v; return nil }},
+	{"TLS_WATCH_INTERVAL", func(c *Config, v string) error { return c.TLS.WatchInterval.UnmarshalText([]byte(v)) }},
+	{"DB_DRIVER", func(c *Config, v string) error { c.Database.Driver = v; return nil }},
+	{"DB_PATH", func(c *Config, v string) error { c.Database.Path = v; return nil }},
+	{"DB_DSN", func(c *Config, v string) error { c.Database.DSN = v; return nil }},
+	{"DB_DSN_FILE", func(c *Config, v string) error { c.Database.DSNFile = v; return nil }},
+	{"DB_HOST", func(c *Config, v string) error { c.Database.Host = v; return nil }},
+	{"DB_NAME", func(c *Config, v string) error { c.Database.Name = v; return nil }},
+	{"DB_USER", func(c *Config, v string) error { c.Database.User = v; return nil }},
+	{"DB_PASSWORD", func(c *Config, v string) error { c.Database.Password = v; return nil }},
+	{"DB_PASSWORD_FILE", func(c *Config, v string) error { c.Database.PasswordFile = v; return nil }},
+	{"DB_PARAMS", func(c *Config, v string) error { c.Database.Params = v; return nil }},
+	{"DB_AUTO_MIGRATE", func(c *Config, v string) error { return setBool(&c.Database.AutoMigrate, v) }},
+	{"DELETE_WINDOW", func(c *Config, v string) error { return c.Messages.DeleteWindow.UnmarshalText([]byte(v)) }},
+	{"EXPIRY_INTERVAL", func(c *Config, v string) error { return c.Messages.ExpiryInterval.UnmarshalText([]byte(v)) }},
+	{"RETENTION_INTERVAL", func(c *Config, v string) error { return c.Retention.Interval.UnmarshalText([]byte(v)) }},
+	{"RETENTION_BATCH_SIZE", func(c *Config, v string) error { return setInt(&c.Retention.BatchSize, v) }},
+	{"RETENTION_MAX_AGE", func(c *Config, v string) error { return c.Retention.MaxAge.UnmarshalText([]byte(v)) }},
+	{"RETENTION_DELIVERED_MAX_AGE", func(c *Config, v string) error {
+		return c.Retention.DeliveredMaxAge.UnmarshalText([]byte(v))
+	}},
+	{"RETENTION_UNDELIVERED_MAX_AGE", func(c *Config, v string) error {
+		return c.Retention.UndeliveredMaxAge.UnmarshalText([]byte(v))
+	}},
+	{"RETENTION_CONTENT_TYPES", func(c *Config, v string) error { return setDurations(&c.Retention.ContentTypes, v) }},
+	{"JWT_SECRET", func(c *Config, v string) error { c.Auth.JWTSecret = v; return nil }},
+	{"TOKEN_TTL", func(c *Config, v string) error { return c.Auth.TokenTTL.UnmarshalText([]byte(v)) }},
+}
+
+// applyEnv overrides fields with any LIBRETALK_* variables that are set.
+func (c *Config) applyEnv() error {
+	for _, ev := range envVars {
+		v, ok := os.LookupEnv(envPrefix + ev.name)
+		if !ok {
+			continue
+		}
+		if err := ev.set(c, v); err != nil {
+			return fmt.Errorf("%s%s: %w", envPrefix, ev.name, err)
+		}
+	}
+	return nil
+}
+
+func setInt64(dst *int64, v string) error {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+func setInt(dst *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+// setDurations parses "file=720h,text=8760h".
+func setDurations(dst *map[string]Duration, v string) error {
+	m := make(map[string]Duration)
+	for _, kv := range splitList(v) {
+		k, d, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("%q is not key=duration", kv)
+		}
+		var dur Duration
+		if err := dur.UnmarshalText([]byte(strings.TrimSpace(d))); err != nil {
+			return err
+		}
+		m[strings.TrimSpace(k)] = dur
+	}
+	*dst = m
+	return nil
+}
+
+func setBool(dst *bool, v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	*dst = b
+	return nil
+}
+
+// splitList parses a comma-separated list, dropping empty entries.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// systemd credential names looked up in $CREDENTIALS_DIRECTORY
+// (see LoadCredential= in systemd.exec(5)).
+const (
+	credDSN      = "db_dsn"
+	credPassword = "db_password"
+)
+
+// loadSecrets fills in DSN and Password from their non-interactive sources.
+// For each, an inline value (file or env) wins, then the *File path, then
+// the systemd credential. A complete DSN makes the password unnecessary.
+func (d *Database) loadSecrets() error {
+	if err := loadSecret(&d.DSN, d.DSNFile, credDSN); err != nil {
+		return fmt.Errorf("database.dsn: %w", err)
+	}
+	if d.DSN != "" {
+		return nil
+	}
+	if err := loadSecret(&d.Password, d.PasswordFile, credPassword); err != nil {
+		return fmt.Errorf("database.password: %w", err)
+	}
+	return nil
+}
+
+func loadSecret(dst *string, file, credential string) error {
+	if *dst != "" {
+		return nil
+	}
+	if file != "" {
+		v, err := readSecretFile(file)
+		if err != nil {
+			return err
+		}
+		*dst = v
+		return nil
+	}
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return nil
+	}
+	v, err := readSecretFile(filepath.Join(dir, credential))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	*dst = v
+	return nil
+}
+
+// readSecretFile reads a secret, dropping the trailing newline most
+// editors and `echo` add.
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read secret: %w", err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
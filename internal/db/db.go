@@ -3,13 +3,36 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
-// Connect opens and pings the database.
-func Connect(dsn string) (*sql.DB, error) {
-	dbc, err := sql.Open("mysql", dsn)
+// Connect opens and pings the database. driver is "mysql", "postgres" or
+// "sqlite"; for SQLite the DSN is the path of the database file.
+func Connect(driver, dsn string) (*sql.DB, error) {
+	var driverName string
+	switch driver {
+	case "mysql":
+		// DATETIME columns are scanned straight into time.Time throughout,
+		// which needs parseTime regardless of what the DSN says.
+		mc, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("parse dsn: %w", err)
+		}
+		mc.ParseTime = true
+		driverName, dsn = "mysql", mc.FormatDSN()
+	case "postgres":
+		driverName = "postgres"
+	case "sqlite":
+		driverName, dsn = "sqlite3", sqliteDSN(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+	dbc, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
@@ -19,3 +42,44 @@ func Connect(dsn string) (*sql.DB, error) {
 	}
 	return dbc, nil
 }
+
+// sqliteDSN turns on WAL, so readers don't block the writer, and makes
+// writers wait for each other instead of failing with SQLITE_BUSY.
+// Options already present in path are left alone.
+func sqliteDSN(path string) string {
+	opts := []string{"_journal_mode=WAL", "_busy_timeout=5000", "_txlock=immediate", "_synchronous=NORMAL"}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	for _, o := range opts {
+		key, _, _ := strings.Cut(o, "=")
+		if strings.Contains(path, key+"=") {
+			continue
+		}
+		path += sep + o
+		sep = "&"
+	}
+	return path
+}
+
+// Rebind rewrites the ? placeholders of query into $1, $2, ... when driver
+// is "postgres"; MySQL and SQLite take ? as is. Queries must not contain a
+// literal '?'.
+func Rebind(driver, query string) string {
+	if driver != "postgres" || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
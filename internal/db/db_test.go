@@ -0,0 +1,15 @@
+package db
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	q := "SELECT a FROM t WHERE b = ? AND c = ?"
+	for _, driver := range []string{"mysql", "sqlite"} {
+		if got := Rebind(driver, q); got != q {
+			t.Errorf("%s query rewritten: %q", driver, got)
+		}
+	}
+	if got, want := Rebind("postgres", q), "SELECT a FROM t WHERE b = $1 AND c = $2"; got != want {
+		t.Errorf("Rebind() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+var started = time.Now()
+
+// check is the JSON detail of one readiness probe.
+type check struct {
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs,omitempty"`
+}
+
+// LiveHandler answers /healthz: the process is up and serving HTTP.
+func LiveHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "ok",
+			"uptime": time.Since(started).Round(time.Second).String(),
+		})
+	}
+}
+
+// Pinger is anything that can check its connection to the database,
+// e.g. *sql.DB or a store.Store.
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// ReadyHandler answers /readyz: the database answers a ping, the upload
+// directory is writable and the server isn't shutting down. Any failure
+// turns the response into a 503 so the instance leaves the load balancer.
+func ReadyHandler(db Pinger, uploadDir string, draining func() bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]check{
+			"database":  timed("database", "database unreachable", func() error { return pingDB(r.Context(), db) }),
+			"uploadDir": timed("uploadDir", "upload directory not writable", func() error { return checkWritable(uploadDir) }),
+			"shutdown":  {OK: true},
+		}
+		if draining() {
+			checks["shutdown"] = check{Error: "shutdown in progress"}
+		}
+
+		status, code := "ok", http.StatusOK
+		for _, c := range checks {
+			if !c.OK {
+				status, code = "unavailable", http.StatusServiceUnavailable
+				break
+			}
+		}
+		writeJSON(w, code, map[string]interface{}{
+			"status": status,
+			"checks": checks,
+		})
+	}
+}
+
+// timed runs one probe. /readyz is unauthenticated, so a failure shows
+// up there only as the fixed text failed; the error itself, which can
+// name hosts, users or paths, goes to the log.
+func timed(name, failed string, fn func() error) check {
+	start := time.Now()
+	err := fn()
+	c := check{OK: err == nil, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		slog.Warn("readiness check failed", "check", name, "err", err)
+		c.Error = failed
+	}
+	return c
+}
+
+func pingDB(ctx context.Context, db Pinger) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return db.PingContext(ctx)
+}
+
+// checkWritable creates and removes a scratch file, creating the directory
+// first the same way the upload handler would.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,94 @@
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Listen opens the listener described by addr:
+//
+//	":8081", "127.0.0.1:8081"   TCP
+//	"unix:/run/libretalk.sock"  Unix domain socket, chmod'ed to mode
+//	"systemd"                   the first socket passed in by systemd
+//	"systemd:<name>"            the socket with FileDescriptorName=<name>
+func Listen(addr string, mode fs.FileMode) (net.Listener, error) {
+	switch {
+	case addr == "systemd":
+		return systemd("")
+	case strings.HasPrefix(addr, "systemd:"):
+		return systemd(strings.TrimPrefix(addr, "systemd:"))
+	case strings.HasPrefix(addr, "unix:"):
+		return unix(strings.TrimPrefix(addr, "unix:"), mode)
+	default:
+		return net.Listen("tcp", addr)
+	}
+}
+
+// unix listens on path, replacing a stale socket left by an earlier run.
+// The socket file is removed again when the listener is closed. It is
+// created under a umask that already leaves out what mode doesn't grant,
+// so it is never reachable with wider permissions before the chmod.
+func unix(path string, mode fs.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("listen %s: exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+	restore := umask(^mode.Perm() & fs.ModePerm)
+	ln, err := net.Listen("unix", path)
+	restore()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("chmod socket: %w", err)
+	}
+	return ln, nil
+}
+
+// listenFDsStart is the first descriptor systemd hands over (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// systemd picks up a socket passed via the LISTEN_PID / LISTEN_FDS /
+// LISTEN_FDNAMES protocol (see sd_listen_fds(3)). An empty name takes the
+// first one. The variables are cleared so child processes don't inherit them.
+func systemd(name string) (net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("systemd: no sockets passed to this process (LISTEN_PID unset or not ours)")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("systemd: LISTEN_FDS is empty")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	for i := 0; i < n; i++ {
+		if name != "" && (i >= len(names) || names[i] != name) {
+			continue
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), "systemd-socket")
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener dups the descriptor
+		if err != nil {
+			return nil, fmt.Errorf("systemd: fd %d: %w", listenFDsStart+i, err)
+		}
+		return ln, nil
+	}
+	return nil, fmt.Errorf("systemd: no socket named %q in LISTEN_FDNAMES", name)
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package listen
+
+import "io/fs"
+
+// umask is a no-op where there is no umask; the chmod after listening
+// still applies the mode.
+func umask(fs.FileMode) (restore func()) { return func() {} }
@@ -0,0 +1,15 @@
+//go:build unix
+
+package listen
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// umask sets the process umask and returns a func that puts the old one
+// back. The umask is process-wide; it is only changed during startup.
+func umask(mask fs.FileMode) (restore func()) {
+	old := syscall.Umask(int(mask))
+	return func() { syscall.Umask(old) }
+}
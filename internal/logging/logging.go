@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/jad0s/libretalk/internal/config"
+)
+
+// redacted replaces the value of any attribute that may carry a secret.
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values never reach the output,
+// wherever they appear (top level or inside a group).
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"jwt":           true,
+	"secret":        true,
+	"dsn":           true,
+	"authorization": true,
+}
+
+// New builds a logger writing to w in the configured format and level.
+func New(c config.Log, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level:       parseLevel(c.Level),
+		ReplaceAttr: redact,
+	}
+	if strings.EqualFold(c.Format, "json") {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// Setup installs the configured logger as the process default, which also
+// routes anything still written through the standard log package.
+func Setup(c config.Log) {
+	slog.SetDefault(New(c, os.Stderr))
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+func parseLevel(s string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
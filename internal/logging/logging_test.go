@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jad0s/libretalk/internal/config"
+	"github.com/jad0s/libretalk/internal/types"
+)
+
+func TestSecretsStayOutOfTheLog(t *testing.T) {
+	secrets := []string{"hunter2", "eyJhbGciOiJIUzI1NiJ9.payload.sig", "user:pass@tcp(db)/chat", "s3cr3t", "Bearer abc"}
+	for _, format := range []string{"text", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			log := New(config.Log{Level: "debug", Format: format}, &buf)
+
+			log.Debug("frame received", "req", types.ActionRequest{
+				Type: "auth", Action: "login", Username: "alice", Password: "hunter2", DeviceID: "phone",
+			})
+			log.Debug("frame received", "req", types.IncomingMessage{
+				Type: "message", From: "alice", To: "bob", ContentType: "text",
+				Content: "hello bob", Token: "eyJhbGciOiJIUzI1NiJ9.payload.sig",
+			})
+			log.Info("open database", "dsn", "user:pass@tcp(db)/chat")
+			log.Warn("login failed", "user", "alice", "password", "hunter2")
+			log.WithGroup("auth").Info("nested", "Secret", "s3cr3t", "Authorization", "Bearer abc")
+
+			out := buf.String()
+			for _, s := range secrets {
+				if strings.Contains(out, s) {
+					t.Errorf("log leaks %q:\n%s", s, out)
+				}
+			}
+			if strings.Contains(out, "hello bob") {
+				t.Errorf("log carries a message body:\n%s", out)
+			}
+			for _, want := range []string{"alice", "phone", "contentLen", redacted} {
+				if !strings.Contains(out, want) {
+					t.Errorf("log lacks %q:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
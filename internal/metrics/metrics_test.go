@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerOutput(t *testing.T) {
+	registryMu.Lock()
+	registry = nil
+	registryMu.Unlock()
+
+	plain := NewCounter("test_plain_total", "A plain counter.")
+	plain.Add("", 2)
+	byType := NewCounterVec("test_frames_total", `Frames by "type", with a \ in the help.`, "type")
+	byType.Inc("message")
+	byType.Inc(`quote " backslash \ newline` + "\n" + `end`)
+	byType.Add("ack", 3)
+	NewGaugeFunc("test_inf", "A gauge at +Inf.", func() float64 { return math.Inf(+1) })
+	h := NewHistogramVec("test_seconds", "A histogram.", "op", []float64{.25, 1})
+	h.Observe("b", 2)
+	h.Observe("a", 0.0625)
+	h.Observe("a", 0.5)
+	h.Observe("a", 2)
+	h.Observe("a", 0.25) // a bound counts into its own bucket
+
+	rec := httptest.NewRecorder()
+	Handler()(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := strings.Join([]string{
+		`# HELP test_plain_total A plain counter.`,
+		`# TYPE test_plain_total counter`,
+		`test_plain_total 2`,
+		`# HELP test_frames_total Frames by "type", with a \\ in the help.`,
+		`# TYPE test_frames_total counter`,
+		`test_frames_total{type="ack"} 3`,
+		`test_frames_total{type="message"} 1`,
+		`test_frames_total{type="quote \" backslash \\ newline\nend"} 1`,
+		`# HELP test_inf A gauge at +Inf.`,
+		`# TYPE test_inf gauge`,
+		`test_inf +Inf`,
+		`# HELP test_seconds A histogram.`,
+		`# TYPE test_seconds histogram`,
+		`test_seconds_bucket{op="a",le="0.25"} 2`,
+		`test_seconds_bucket{op="a",le="1"} 3`,
+		`test_seconds_bucket{op="a",le="+Inf"} 4`,
+		`test_seconds_sum{op="a"} 2.8125`,
+		`test_seconds_count{op="a"} 4`,
+		`test_seconds_bucket{op="b",le="0.25"} 0`,
+		`test_seconds_bucket{op="b",le="1"} 0`,
+		`test_seconds_bucket{op="b",le="+Inf"} 1`,
+		`test_seconds_sum{op="b"} 2`,
+		`test_seconds_count{op="b"} 1`,
+	}, "\n") + "\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("/metrics =\n%s\nwant\n%s", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
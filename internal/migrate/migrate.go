@@ -0,0 +1,316 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dbutil "github.com/jad0s/libretalk/internal/db"
+)
+
+// files holds one directory of migrations per database driver. The
+// directories are kept in step: version N means the same schema everywhere.
+//
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// Migration is one numbered schema change, read from a pair of files named
+// NNNN_name.up.sql and NNNN_name.down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// State is a migration together with whether it has been applied.
+type State struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Bundled returns the migrations compiled into the binary for driver
+// ("mysql", "postgres" or "sqlite"), oldest first.
+func Bundled(driver string) ([]Migration, error) {
+	return load(files, driver)
+}
+
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		var up bool
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			up = true
+		case strings.HasSuffix(name, ".down.sql"):
+		default:
+			continue
+		}
+		num, rest, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected NNNN_name", name)
+		}
+		v, err := strconv.Atoi(num)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: bad version: %w", name, err)
+		}
+		body, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", name, err)
+		}
+		m := byVersion[v]
+		if m == nil {
+			m = &Migration{Version: v}
+			byVersion[v] = m
+		}
+		if up {
+			m.Name = strings.TrimSuffix(rest, ".up.sql")
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d has no .up.sql", m.Version)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// ensureTable creates the bookkeeping table if it is missing.
+func ensureTable(db *sql.DB, driver string) error {
+	timestamp := "DATETIME"
+	if driver == "postgres" {
+		timestamp = "TIMESTAMPTZ"
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version    INT          NOT NULL PRIMARY KEY,
+			name       VARCHAR(255) NOT NULL,
+			applied_at ` + timestamp + ` NOT NULL
+		)`)
+	if err != nil {
+		return fmt.Errorf("create schema_version: %w", err)
+	}
+	return nil
+}
+
+// applied returns version -> applied_at for every recorded migration.
+func applied(db *sql.DB, driver string) (map[int]time.Time, error) {
+	if err := ensureTable(db, driver); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("read schema_version: %w", err)
+	}
+	defer rows.Close()
+	done := map[int]time.Time{}
+	for rows.Next() {
+		var v int
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, fmt.Errorf("scan schema_version: %w", err)
+		}
+		done[v] = at
+	}
+	return done, rows.Err()
+}
+
+// Status lists every bundled migration and whether it has been applied.
+func Status(db *sql.DB, driver string) ([]State, error) {
+	all, err := Bundled(driver)
+	if err != nil {
+		return nil, err
+	}
+	done, err := applied(db, driver)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]State, len(all))
+	for i, m := range all {
+		at, ok := done[m.Version]
+		states[i] = State{Migration: m, Applied: ok, AppliedAt: at}
+	}
+	return states, nil
+}
+
+// Pending returns the bundled migrations that have not been applied yet.
+func Pending(db *sql.DB, driver string) ([]Migration, error) {
+	states, err := Status(db, driver)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, s := range states {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// lockName is the advisory lock that keeps two instances starting at the
+// same time from migrating concurrently.
+const lockName = "libretalk_migrate"
+
+// lockTimeout is how long to wait for another instance's migration.
+const lockTimeout = 60 * time.Second
+
+// lock pins one connection and takes the migration lock on it. The returned
+// func releases the lock and the connection. SQLite has no advisory locks;
+// a database file belongs to a single server anyway.
+func lock(db *sql.DB, driver string) (*sql.Conn, func(), error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("migration lock: %w", err)
+	}
+	switch driver {
+	case "sqlite":
+		return conn, func() { conn.Close() }, nil
+	case "postgres":
+		// pg_advisory_lock has no timeout of its own but honours lock_timeout
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET lock_timeout = %d", lockTimeout.Milliseconds())); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("migration lock: %w", err)
+		}
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", lockName); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("migration lock: %w", err)
+		}
+		release := func() {
+			conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1))", lockName)
+			conn.ExecContext(ctx, "RESET lock_timeout")
+			conn.Close()
+		}
+		return conn, release, nil
+	}
+	var got sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, int(lockTimeout.Seconds())).Scan(&got); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("migration lock: %w", err)
+	}
+	if got.Int64 != 1 {
+		conn.Close()
+		return nil, nil, fmt.Errorf("migration lock: timed out waiting for another instance")
+	}
+	release := func() {
+		conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockName)
+		conn.Close()
+	}
+	return conn, release, nil
+}
+
+// Up applies every pending migration in order and returns the ones it ran.
+func Up(db *sql.DB, driver string) ([]Migration, error) {
+	conn, release, err := lock(db, driver)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// read the state only once we hold the lock, another instance may
+	// have just finished migrating
+	states, err := Status(db, driver)
+	if err != nil {
+		return nil, err
+	}
+	var ran []Migration
+	for _, s := range states {
+		if s.Applied {
+			continue
+		}
+		if err := execScript(conn, s.Up); err != nil {
+			return ran, fmt.Errorf("migration %04d_%s: %w", s.Version, s.Name, err)
+		}
+		if _, err := conn.ExecContext(context.Background(),
+			dbutil.Rebind(driver, "INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)"),
+			s.Version, s.Name, time.Now().UTC(),
+		); err != nil {
+			return ran, fmt.Errorf("record migration %04d: %w", s.Version, err)
+		}
+		ran = append(ran, s.Migration)
+	}
+	return ran, nil
+}
+
+// Down rolls back the newest `steps` applied migrations and returns them.
+func Down(db *sql.DB, driver string, steps int) ([]Migration, error) {
+	conn, release, err := lock(db, driver)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	states, err := Status(db, driver)
+	if err != nil {
+		return nil, err
+	}
+	var ran []Migration
+	for i := len(states) - 1; i >= 0 && len(ran) < steps; i-- {
+		s := states[i]
+		if !s.Applied {
+			continue
+		}
+		if s.Down == "" {
+			return ran, fmt.Errorf("migration %04d_%s cannot be rolled back", s.Version, s.Name)
+		}
+		if err := execScript(conn, s.Down); err != nil {
+			return ran, fmt.Errorf("rollback %04d_%s: %w", s.Version, s.Name, err)
+		}
+		if _, err := conn.ExecContext(context.Background(),
+			dbutil.Rebind(driver, "DELETE FROM schema_version WHERE version = ?"), s.Version); err != nil {
+			return ran, fmt.Errorf("unrecord migration %04d: %w", s.Version, err)
+		}
+		ran = append(ran, s.Migration)
+	}
+	return ran, nil
+}
+
+// execScript runs each statement of a migration file in turn. Statements
+// end with a ';' at the end of a line; "--" comment lines are skipped.
+// MySQL commits DDL implicitly, so there is no surrounding transaction on
+// any driver.
+func execScript(conn *sql.Conn, script string) error {
+	ctx := context.Background()
+	var stmt strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		stmt.WriteString(line)
+		stmt.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			if _, err := conn.ExecContext(ctx, stmt.String()); err != nil {
+				return err
+			}
+			stmt.Reset()
+		}
+	}
+	if strings.TrimSpace(stmt.String()) != "" {
+		if _, err := conn.ExecContext(ctx, stmt.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
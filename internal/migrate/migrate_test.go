@@ -0,0 +1,99 @@
+package migrate
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jad0s/libretalk/internal/db"
+)
+
+func TestBundled(t *testing.T) {
+	versions := make(map[string][]int)
+	for _, driver := range []string{"mysql", "postgres", "sqlite"} {
+		ms, err := Bundled(driver)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		for i, m := range ms {
+			if m.Version != i+1 {
+				t.Errorf("%s: migration %d has version %d, want %d", driver, i, m.Version, i+1)
+			}
+			if m.Up == "" || m.Down == "" {
+				t.Errorf("%s: %04d_%s lacks an up or down script", driver, m.Version, m.Name)
+			}
+			versions[driver] = append(versions[driver], m.Version)
+		}
+	}
+	// every driver must carry the same schema history
+	if len(versions["mysql"]) != len(versions["postgres"]) || len(versions["mysql"]) != len(versions["sqlite"]) {
+		t.Errorf("drivers disagree on migrations: %v", versions)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"d/0002_b.up.sql":   {Data: []byte("B")},
+		"d/0002_b.down.sql": {Data: []byte("b")},
+		"d/0001_a.up.sql":   {Data: []byte("A")},
+		"d/README":          {Data: []byte("ignored")},
+	}
+	ms, err := load(fsys, "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 || ms[0].Name != "a" || ms[1].Name != "b" || ms[1].Down != "b" || ms[0].Down != "" {
+		t.Errorf("load() = %+v", ms)
+	}
+
+	fsys["d/0003_c.down.sql"] = &fstest.MapFile{Data: []byte("c")}
+	if _, err := load(fsys, "d"); err == nil {
+		t.Error("a down script without its up script was accepted")
+	}
+}
+
+// TestUpDown runs the whole SQLite history up, all the way down and up
+// again, which catches down scripts that don't undo their up script.
+func TestUpDown(t *testing.T) {
+	database, err := db.Connect("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	all, err := Bundled("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ran, err := Up(database, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != len(all) {
+		t.Fatalf("Up ran %d migrations, want %d", len(ran), len(all))
+	}
+	if pending, err := Pending(database, "sqlite"); err != nil || len(pending) != 0 {
+		t.Fatalf("Pending() = %v, %v after Up", pending, err)
+	}
+
+	back, err := Down(database, "sqlite", len(all))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back) != len(all) || back[0].Version != all[len(all)-1].Version {
+		t.Fatalf("Down rolled back %d migrations starting at %d", len(back), back[0].Version)
+	}
+	if ran, err := Up(database, "sqlite"); err != nil || len(ran) != len(all) {
+		t.Fatalf("second Up ran %d migrations: %v", len(ran), err)
+	}
+
+	states, err := Status(database, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range states {
+		if !s.Applied {
+			t.Errorf("%04d_%s not applied", s.Version, s.Name)
+		}
+	}
+}
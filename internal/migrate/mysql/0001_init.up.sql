@@ -0,0 +1,43 @@
+-- Base schema. IF NOT EXISTS lets this run against databases that were
+-- set up by hand before migrations existed.
+
+CREATE TABLE IF NOT EXISTS users (
+    id            BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    username      VARCHAR(64)  NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at    DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_users_username (username)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS messages (
+    id           BIGINT      NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    sender       VARCHAR(64) NOT NULL,
+    recipient    VARCHAR(64) NOT NULL,
+    content_type VARCHAR(32) NOT NULL,
+    content      TEXT        NOT NULL,
+    sent_at      DATETIME    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered    BOOLEAN     NOT NULL DEFAULT FALSE,
+    delivered_at DATETIME    NULL,
+    KEY idx_messages_pair (sender, recipient, sent_at),
+    KEY idx_messages_undelivered (recipient, delivered, sent_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS conversations (
+    id           BIGINT      NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user1        VARCHAR(64) NOT NULL,
+    user2        VARCHAR(64) NOT NULL,
+    last_message TEXT        NOT NULL,
+    updated_at   DATETIME    NOT NULL,
+    UNIQUE KEY uq_conversations_pair (user1, user2),
+    KEY idx_conversations_user2 (user2)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS files (
+    id            CHAR(36)     NOT NULL PRIMARY KEY,
+    uploader      VARCHAR(64)  NOT NULL,
+    original_name VARCHAR(255) NOT NULL,
+    content_type  VARCHAR(255) NOT NULL,
+    size_bytes    BIGINT       NOT NULL,
+    uploaded_at   DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_files_uploader (uploader)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
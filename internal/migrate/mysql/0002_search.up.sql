@@ -0,0 +1,3 @@
+-- Full-text index behind the search frame (MATCH ... AGAINST in boolean mode).
+
+ALTER TABLE messages ADD FULLTEXT INDEX ft_messages_content (content);
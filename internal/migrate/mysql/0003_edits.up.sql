@@ -0,0 +1,12 @@
+-- Message editing: the current text stays in messages.content, every
+-- replaced version is kept in message_edits.
+
+ALTER TABLE messages ADD COLUMN edited_at DATETIME NULL;
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    id          BIGINT   NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    message_id  BIGINT   NOT NULL,
+    old_content TEXT     NOT NULL,
+    edited_at   DATETIME NOT NULL,
+    KEY idx_message_edits_message (message_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
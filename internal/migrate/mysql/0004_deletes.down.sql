@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS message_hidden;
+ALTER TABLE messages DROP COLUMN deleted_at;
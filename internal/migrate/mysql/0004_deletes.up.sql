@@ -0,0 +1,12 @@
+-- Message deletion. Deleting for everyone blanks messages.content and
+-- stamps deleted_at, leaving a tombstone in the conversation; deleting for
+-- yourself only adds a row to message_hidden.
+
+ALTER TABLE messages ADD COLUMN deleted_at DATETIME NULL;
+
+CREATE TABLE IF NOT EXISTS message_hidden (
+    message_id BIGINT      NOT NULL,
+    username   VARCHAR(64) NOT NULL,
+    hidden_at  DATETIME    NOT NULL,
+    PRIMARY KEY (message_id, username)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
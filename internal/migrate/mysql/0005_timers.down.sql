@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP INDEX idx_messages_expires;
+ALTER TABLE messages DROP COLUMN expires_at;
+ALTER TABLE conversations DROP COLUMN disappear_after;
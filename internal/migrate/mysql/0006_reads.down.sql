@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP INDEX idx_messages_unread;
+ALTER TABLE conversations DROP COLUMN user2_read_id;
+ALTER TABLE conversations DROP COLUMN user1_read_id;
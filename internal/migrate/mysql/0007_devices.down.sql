@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP INDEX idx_messages_sync;
+DROP TABLE IF EXISTS devices;
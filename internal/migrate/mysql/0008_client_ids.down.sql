@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP INDEX uq_messages_client;
+ALTER TABLE messages DROP COLUMN client_msg_id;
@@ -0,0 +1,11 @@
+-- Acks a device sent for messages above its sync cursor. The cursor only
+-- moves over messages the device has acked without a gap, so an ack that
+-- arrives early or one that never arrives can not skip a message; rows
+-- at or below the cursor are dropped as it passes them.
+
+CREATE TABLE IF NOT EXISTS device_acks (
+    username   VARCHAR(64) NOT NULL,
+    device_id  VARCHAR(64) NOT NULL,
+    message_id BIGINT      NOT NULL,
+    PRIMARY KEY (username, device_id, message_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP INDEX idx_messages_file;
+ALTER TABLE messages DROP COLUMN file_id;
@@ -0,0 +1,21 @@
+-- File references. A file message keeps the files.id of the upload it links
+-- to, so finding out whether an upload is still referenced is an index
+-- lookup instead of a scan over every message's content. Existing file
+-- messages get theirs from the upload URL by the rule uploadName in
+-- internal/chat/store applies to new ones: the name after the last
+-- "/uploads/", if it has no slash or backslash and doesn't start with a
+-- dot, up to its first dot, and no longer than 36 bytes.
+
+ALTER TABLE messages ADD COLUMN file_id CHAR(36) NULL;
+
+ALTER TABLE messages ADD KEY idx_messages_file (file_id);
+
+UPDATE messages
+   SET file_id = SUBSTRING_INDEX(SUBSTRING_INDEX(content, '/uploads/', -1), '.', 1)
+ WHERE content_type = 'file'
+   AND SUBSTRING_INDEX(content, '/uploads/', -1) <> content
+   AND SUBSTRING_INDEX(content, '/uploads/', -1) <> ''
+   AND LEFT(SUBSTRING_INDEX(content, '/uploads/', -1), 1) <> '.'
+   AND LOCATE('/', SUBSTRING_INDEX(content, '/uploads/', -1)) = 0
+   AND LOCATE('\\', SUBSTRING_INDEX(content, '/uploads/', -1)) = 0
+   AND LENGTH(SUBSTRING_INDEX(SUBSTRING_INDEX(content, '/uploads/', -1), '.', 1)) <= 36;
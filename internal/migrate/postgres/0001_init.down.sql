@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS files;
+DROP TABLE IF EXISTS conversations;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS users;
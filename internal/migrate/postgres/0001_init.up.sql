@@ -0,0 +1,41 @@
+-- Base schema, the PostgreSQL twin of mysql/0001_init.up.sql.
+
+CREATE TABLE IF NOT EXISTS users (
+    id            BIGINT       GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    username      VARCHAR(64)  NOT NULL UNIQUE,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at    TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id           BIGINT      GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    sender       VARCHAR(64) NOT NULL,
+    recipient    VARCHAR(64) NOT NULL,
+    content_type VARCHAR(32) NOT NULL,
+    content      TEXT        NOT NULL,
+    sent_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    delivered    BOOLEAN     NOT NULL DEFAULT FALSE,
+    delivered_at TIMESTAMPTZ NULL
+);
+CREATE INDEX IF NOT EXISTS idx_messages_pair ON messages (sender, recipient, sent_at);
+CREATE INDEX IF NOT EXISTS idx_messages_undelivered ON messages (recipient, delivered, sent_at);
+
+CREATE TABLE IF NOT EXISTS conversations (
+    id           BIGINT      GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user1        VARCHAR(64) NOT NULL,
+    user2        VARCHAR(64) NOT NULL,
+    last_message TEXT        NOT NULL,
+    updated_at   TIMESTAMPTZ NOT NULL,
+    CONSTRAINT uq_conversations_pair UNIQUE (user1, user2)
+);
+CREATE INDEX IF NOT EXISTS idx_conversations_user2 ON conversations (user2);
+
+CREATE TABLE IF NOT EXISTS files (
+    id            CHAR(36)     NOT NULL PRIMARY KEY,
+    uploader      VARCHAR(64)  NOT NULL,
+    original_name VARCHAR(255) NOT NULL,
+    content_type  VARCHAR(255) NOT NULL,
+    size_bytes    BIGINT       NOT NULL,
+    uploaded_at   TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_files_uploader ON files (uploader);
@@ -0,0 +1,4 @@
+-- Full-text index behind the search frame. The 'simple' configuration
+-- does no stemming or stop words, so it works the same for every language.
+
+CREATE INDEX IF NOT EXISTS idx_messages_content_fts ON messages USING GIN (to_tsvector('simple', content));
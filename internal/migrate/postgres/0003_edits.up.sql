@@ -0,0 +1,12 @@
+-- Message editing: the current text stays in messages.content, every
+-- replaced version is kept in message_edits.
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ NULL;
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    id          BIGINT      GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    message_id  BIGINT      NOT NULL,
+    old_content TEXT        NOT NULL,
+    edited_at   TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_message_edits_message ON message_edits (message_id);
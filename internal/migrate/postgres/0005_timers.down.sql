@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_messages_expires;
+ALTER TABLE messages DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE conversations DROP COLUMN IF EXISTS disappear_after;
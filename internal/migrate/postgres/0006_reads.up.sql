@@ -0,0 +1,9 @@
+-- Read receipts. Each side of a conversation has a read cursor, the ID of
+-- the newest message from the other side it has read; messages above it
+-- are unread. idx_messages_unread serves the counts in the chat list.
+
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS user1_read_id BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS user2_read_id BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_messages_unread ON messages (recipient, sender, id);
@@ -0,0 +1,15 @@
+-- Devices. A client that logs in with a device ID gets a row here whose
+-- synced_id is the newest message sent to the user that the device has
+-- acknowledged; on every login it is sent what came after. idx_messages_sync
+-- serves that replay.
+
+CREATE TABLE IF NOT EXISTS devices (
+    username     VARCHAR(64) NOT NULL,
+    device_id    VARCHAR(64) NOT NULL,
+    synced_id    BIGINT      NOT NULL DEFAULT 0,
+    created_at   TIMESTAMPTZ NOT NULL,
+    last_seen_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (username, device_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_sync ON messages (recipient, id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS uq_messages_client;
+ALTER TABLE messages DROP COLUMN IF EXISTS client_msg_id;
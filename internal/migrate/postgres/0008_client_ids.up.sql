@@ -0,0 +1,7 @@
+-- Client message IDs. A client may tag what it sends with its own ID;
+-- a retry carrying the same one gets the stored message back instead of
+-- a second copy. The unique key makes that hold across concurrent retries.
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_msg_id VARCHAR(64) NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_messages_client ON messages (sender, client_msg_id);
@@ -0,0 +1,16 @@
+-- File references. A file message keeps the files.id of the upload it links
+-- to, so finding out whether an upload is still referenced is an index
+-- lookup instead of a scan over every message's content. Existing file
+-- messages get theirs from the upload URL by the rule uploadName in
+-- internal/chat/store applies to new ones: the name after the last
+-- "/uploads/", if it has no slash or backslash and doesn't start with a
+-- dot, up to its first dot, and no longer than 36 bytes.
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS file_id CHAR(36) NULL;
+
+CREATE INDEX IF NOT EXISTS idx_messages_file ON messages (file_id);
+
+UPDATE messages
+   SET file_id = split_part(substring(content from '/uploads/([^./\\][^/\\]*)$'), '.', 1)
+ WHERE content_type = 'file'
+   AND octet_length(split_part(substring(content from '/uploads/([^./\\][^/\\]*)$'), '.', 1)) <= 36;
@@ -0,0 +1,42 @@
+-- Base schema, the SQLite twin of mysql/0001_init.up.sql. Timestamps are
+-- written by the server in UTC.
+
+CREATE TABLE IF NOT EXISTS users (
+    id            INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+    username      TEXT     NOT NULL UNIQUE,
+    password_hash TEXT     NOT NULL,
+    created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id           INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+    sender       TEXT     NOT NULL,
+    recipient    TEXT     NOT NULL,
+    content_type TEXT     NOT NULL,
+    content      TEXT     NOT NULL,
+    sent_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered    BOOLEAN  NOT NULL DEFAULT FALSE,
+    delivered_at DATETIME NULL
+);
+CREATE INDEX IF NOT EXISTS idx_messages_pair ON messages (sender, recipient, sent_at);
+CREATE INDEX IF NOT EXISTS idx_messages_undelivered ON messages (recipient, delivered, sent_at);
+
+CREATE TABLE IF NOT EXISTS conversations (
+    id           INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user1        TEXT     NOT NULL,
+    user2        TEXT     NOT NULL,
+    last_message TEXT     NOT NULL,
+    updated_at   DATETIME NOT NULL,
+    UNIQUE (user1, user2)
+);
+CREATE INDEX IF NOT EXISTS idx_conversations_user2 ON conversations (user2);
+
+CREATE TABLE IF NOT EXISTS files (
+    id            TEXT     NOT NULL PRIMARY KEY,
+    uploader      TEXT     NOT NULL,
+    original_name TEXT     NOT NULL,
+    content_type  TEXT     NOT NULL,
+    size_bytes    INTEGER  NOT NULL,
+    uploaded_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_files_uploader ON files (uploader);
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS messages_fts_ai;
+DROP TRIGGER IF EXISTS messages_fts_au;
+DROP TRIGGER IF EXISTS messages_fts_bd;
+DROP TRIGGER IF EXISTS messages_fts_bu;
+DROP TABLE IF EXISTS messages_fts;
@@ -0,0 +1,12 @@
+-- Message editing: the current text stays in messages.content, every
+-- replaced version is kept in message_edits.
+
+ALTER TABLE messages ADD COLUMN edited_at DATETIME NULL;
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    id          INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+    message_id  INTEGER  NOT NULL,
+    old_content TEXT     NOT NULL,
+    edited_at   DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_message_edits_message ON message_edits (message_id);
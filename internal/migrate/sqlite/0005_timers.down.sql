@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_messages_expires;
+ALTER TABLE messages DROP COLUMN expires_at;
+ALTER TABLE conversations DROP COLUMN disappear_after;
@@ -0,0 +1,9 @@
+-- Disappearing messages. A conversation's timer (in seconds, 0 = off)
+-- stamps expires_at on every message sent while it is set; a sweeper
+-- deletes messages once that time has passed.
+
+ALTER TABLE conversations ADD COLUMN disappear_after BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE messages ADD COLUMN expires_at DATETIME NULL;
+
+CREATE INDEX IF NOT EXISTS idx_messages_expires ON messages (expires_at);
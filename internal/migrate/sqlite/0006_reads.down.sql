@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_messages_unread;
+ALTER TABLE conversations DROP COLUMN user2_read_id;
+ALTER TABLE conversations DROP COLUMN user1_read_id;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_sync;
+DROP TABLE IF EXISTS devices;
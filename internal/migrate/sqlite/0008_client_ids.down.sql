@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS uq_messages_client;
+ALTER TABLE messages DROP COLUMN client_msg_id;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_file;
+ALTER TABLE messages DROP COLUMN file_id;
@@ -0,0 +1,28 @@
+-- File references. A file message keeps the files.id of the upload it links
+-- to, so finding out whether an upload is still referenced is an index
+-- lookup instead of a scan over every message's content. Existing file
+-- messages get theirs from the upload URL by the rule uploadName in
+-- internal/chat/store applies to new ones: the name after the last
+-- "/uploads/", if it has no slash or backslash and doesn't start with a
+-- dot, up to its first dot, and no longer than 36 bytes.
+
+ALTER TABLE messages ADD COLUMN file_id TEXT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_messages_file ON messages (file_id);
+
+WITH f AS (
+  SELECT id,
+         rtrim(content, replace(content, '/', '')) AS dir,
+         substr(content, length(rtrim(content, replace(content, '/', ''))) + 1) AS name
+    FROM messages
+   WHERE content_type = 'file'
+), ids AS (
+  SELECT id,
+         CASE WHEN instr(name, '.') > 0 THEN substr(name, 1, instr(name, '.') - 1) ELSE name END AS file_id
+    FROM f
+   WHERE substr(dir, -9) = '/uploads/'
+     AND name <> '' AND substr(name, 1, 1) <> '.' AND instr(name, '\') = 0
+)
+UPDATE messages
+   SET file_id = (SELECT file_id FROM ids WHERE ids.id = messages.id)
+ WHERE id IN (SELECT id FROM ids WHERE length(CAST(file_id AS BLOB)) <= 36);
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// v2Signature opens every PROXY protocol v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// headerTimeout bounds how long a trusted peer gets to send its header.
+const headerTimeout = 5 * time.Second
+
+// Listen wraps l so connections from trusted proxies may start with a
+// PROXY protocol (v1 or v2) header naming the real client. The header is
+// read lazily on the connection's own goroutine, so a slow peer never
+// stalls Accept. Untrusted peers are never parsed.
+func (r *Resolver) Listen(l net.Listener) net.Listener {
+	return &listener{Listener: l, resolver: r}
+}
+
+type listener struct {
+	net.Listener
+	resolver *Resolver
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	// anyone who can open a Unix socket is a local proxy we were told to
+	// sit behind, so those are parsed too
+	if c.LocalAddr().Network() != "unix" {
+		peer, ok := parseHost(c.RemoteAddr().String())
+		if !ok || !l.resolver.Trusted(peer) {
+			return c, nil
+		}
+	}
+	return &conn{Conn: c, br: bufio.NewReader(c), remote: c.RemoteAddr()}, nil
+}
+
+// conn replaces RemoteAddr with the address from the PROXY header.
+type conn struct {
+	net.Conn
+	br *bufio.Reader
+
+	once   sync.Once
+	err    error
+	remote net.Addr
+}
+
+func (c *conn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(headerTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+		addr, err := readHeader(c.br)
+		if err != nil {
+			slog.Warn("proxy protocol: bad header, closing", "peer", c.Conn.RemoteAddr().String(), "err", err)
+			c.err = err
+			c.Conn.Close()
+			return
+		}
+		if addr != nil {
+			c.remote = addr
+		}
+	})
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(b)
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// readHeader consumes a v1 or v2 header if one is present. It returns a nil
+// address when the peer sent none, or sent one without a client address
+// (v1 UNKNOWN, v2 LOCAL), in which case the peer's own address stands.
+func readHeader(br *bufio.Reader) (net.Addr, error) {
+	sig, err := br.Peek(len(v2Signature))
+	switch {
+	case err == nil && bytes.Equal(sig, v2Signature):
+		return readV2(br)
+	case len(sig) >= 6 && string(sig[:6]) == "PROXY ":
+		return readV1(br)
+	case err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull):
+		return nil, err
+	default:
+		return nil, nil // plain connection from a trusted peer
+	}
+}
+
+// readV1 parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n".
+func readV1(br *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 { // the spec's maximum header length
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("v1 header not terminated by CRLF")
+	}
+	f := strings.Fields(s)
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed v1 header %q", s)
+	}
+	ip, err := netip.ParseAddr(f[2])
+	if err != nil {
+		return nil, fmt.Errorf("v1 source address: %w", err)
+	}
+	port, err := strconv.ParseUint(f[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("v1 source port: %w", err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readV2 parses the binary header: signature, version/command, family,
+// length, then the addresses.
+func readV2(br *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+	if hdr[12]&0x0f == 0 { // LOCAL: health check from the proxy itself
+		return nil, nil
+	}
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, errors.New("short v2 IPv4 address block")
+		}
+		ip := netip.AddrFrom4([4]byte(body[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(body[8:10]))), nil
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, errors.New("short v2 IPv6 address block")
+		}
+		ip := netip.AddrFrom16([16]byte(body[0:16])).Unmap()
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(body[32:34]))), nil
+	default: // AF_UNSPEC / AF_UNIX: nothing useful to report
+		return nil, nil
+	}
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// v2Header builds a v2 header with the given command, family byte and
+// address block.
+func v2Header(cmd, family byte, addrs []byte) string {
+	h := append([]byte{}, v2Signature...)
+	h = append(h, 0x20|cmd, family)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(addrs)))
+	return string(append(h, addrs...))
+}
+
+func TestReadHeader(t *testing.T) {
+	v4 := []byte{203, 0, 113, 7, 10, 0, 0, 1, 0x30, 0x39, 0x01, 0xbb} // 203.0.113.7:12345 -> 10.0.0.1:443
+	v6 := make([]byte, 36)
+	copy(v6, net.ParseIP("2001:db8::1").To16())
+	binary.BigEndian.PutUint16(v6[32:], 4242)
+
+	tests := []struct {
+		name    string
+		in      string
+		want    string // "" for no address
+		wantErr bool
+		rest    string // what the application reads afterwards
+	}{
+		{name: "v1 tcp4", in: "PROXY TCP4 203.0.113.7 10.0.0.1 12345 443\r\nGET /", want: "203.0.113.7:12345", rest: "GET /"},
+		{name: "v1 tcp6", in: "PROXY TCP6 2001:db8::1 2001:db8::2 4242 443\r\nx", want: "[2001:db8::1]:4242", rest: "x"},
+		{name: "v1 unknown", in: "PROXY UNKNOWN\r\nx", rest: "x"},
+		{name: "v1 no crlf", in: "PROXY TCP4 203.0.113.7 10.0.0.1 12345 443\nx", wantErr: true},
+		{name: "v1 bad address", in: "PROXY TCP4 nope 10.0.0.1 12345 443\r\n", wantErr: true},
+		{name: "v1 bad port", in: "PROXY TCP4 203.0.113.7 10.0.0.1 99999 443\r\n", wantErr: true},
+		{name: "v1 too few fields", in: "PROXY TCP4 203.0.113.7\r\n", wantErr: true},
+		{name: "v2 ipv4", in: v2Header(1, 0x11, v4) + "x", want: "203.0.113.7:12345", rest: "x"},
+		{name: "v2 ipv6", in: v2Header(1, 0x21, v6) + "x", want: "[2001:db8::1]:4242", rest: "x"},
+		{name: "v2 local", in: v2Header(0, 0x11, v4) + "x", rest: "x"},
+		{name: "v2 unspec", in: v2Header(1, 0x00, nil) + "x", rest: "x"},
+		{name: "v2 short ipv4", in: v2Header(1, 0x11, v4[:8]), wantErr: true},
+		{name: "v2 truncated", in: v2Header(1, 0x11, v4)[:20], wantErr: true},
+		{name: "no header", in: "GET / HTTP/1.1\r\n", rest: "GET / HTTP/1.1\r\n"},
+		{name: "short plain", in: "hi", rest: "hi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(tt.in))
+			addr, err := readHeader(br)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("readHeader() = %v, want an error", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readHeader(): %v", err)
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.want {
+				t.Errorf("address = %q, want %q", got, tt.want)
+			}
+			rest, _ := io.ReadAll(br)
+			if string(rest) != tt.rest {
+				t.Errorf("left over %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Resolver works out the real client address for connections that arrive
+// through a reverse proxy. Only peers inside one of the trusted prefixes
+// may speak for someone else; everyone else is taken at face value.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver parses CIDRs ("10.0.0.0/8") or bare addresses ("127.0.0.1").
+func NewResolver(cidrs []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, c := range cidrs {
+		var p netip.Prefix
+		var err error
+		if strings.Contains(c, "/") {
+			p, err = netip.ParsePrefix(c)
+		} else {
+			var a netip.Addr
+			a, err = netip.ParseAddr(c)
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", c, err)
+		}
+		r.trusted = append(r.trusted, p.Masked())
+	}
+	return r, nil
+}
+
+// Trusted reports whether addr is one of the configured proxies.
+func (r *Resolver) Trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range r.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of whoever sent req. When the direct peer is
+// a trusted proxy, X-Forwarded-For is walked from the right and the first
+// hop that isn't a trusted proxy wins. Peers without an IP address are on
+// a Unix socket, i.e. a local proxy, and are always trusted.
+func (r *Resolver) clientIP(req *http.Request) string {
+	peer, ok := parseHost(req.RemoteAddr)
+	if ok && !r.Trusted(peer) {
+		return peer.String()
+	}
+
+	var hops []string
+	for _, h := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		a, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break // garbage: stop at the last hop we could make sense of
+		}
+		client = a.Unmap()
+		if !r.Trusted(client) {
+			break
+		}
+	}
+	if !client.IsValid() {
+		return localPeer
+	}
+	return client.String()
+}
+
+// localPeer stands in for the client address of a Unix socket connection
+// that didn't say who it forwards for.
+const localPeer = "local"
+
+type ctxKey struct{}
+
+// Middleware resolves the client address once per request and stores it
+// for ClientIP.
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), ctxKey{}, r.clientIP(req))
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// ClientIP returns the client address resolved by Middleware, or the host
+// part of RemoteAddr when the request didn't pass through it.
+func ClientIP(req *http.Request) string {
+	if ip, ok := req.Context().Value(ctxKey{}).(string); ok {
+		return ip
+	}
+	if a, ok := parseHost(req.RemoteAddr); ok {
+		return a.String()
+	}
+	return req.RemoteAddr
+}
+
+func parseHost(hostport string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	a, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return a.Unmap(), true
+}
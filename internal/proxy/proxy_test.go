@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestNewResolver(t *testing.T) {
+	r, err := NewResolver([]string{"10.0.0.0/8", "192.168.1.5", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"10.1.2.3":           true,
+		"::ffff:10.1.2.3":    true, // v4-mapped counts as the v4 address
+		"192.168.1.5":        true,
+		"192.168.1.6":        false,
+		"::1":                true,
+		"2001:db8::1":        false,
+		"11.0.0.1":           false,
+		"::ffff:192.168.1.5": true,
+	} {
+		if got := r.Trusted(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Trusted(%s) = %v, want %v", addr, got, want)
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "example.org", ""} {
+		if _, err := NewResolver([]string{bad}); err == nil {
+			t.Errorf("NewResolver(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r, err := NewResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{name: "direct", remote: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "untrusted peer can't forward", remote: "203.0.113.7:5000", xff: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "trusted peer without header", remote: "10.0.0.2:5000", want: "10.0.0.2"},
+		{name: "trusted peer", remote: "10.0.0.2:5000", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "rightmost untrusted hop wins", remote: "10.0.0.2:5000", xff: []string{"1.1.1.1, 198.51.100.1, 10.0.0.3"}, want: "198.51.100.1"},
+		{name: "several headers", remote: "10.0.0.2:5000", xff: []string{"1.1.1.1", "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "garbage stops the walk", remote: "10.0.0.2:5000", xff: []string{"1.1.1.1, junk, 10.0.0.3"}, want: "10.0.0.3"},
+		{name: "unix socket peer", remote: "@", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "unix socket peer without header", remote: "@", want: localPeer},
+		{name: "v4-mapped peer", remote: "[::ffff:203.0.113.7]:5000", want: "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remote
+			for _, h := range tt.xff {
+				req.Header.Add("X-Forwarded-For", h)
+			}
+			if got := r.clientIP(req); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jad0s/libretalk/internal/chat/store"
+	"github.com/jad0s/libretalk/internal/config"
+	"github.com/jad0s/libretalk/internal/metrics"
+	"github.com/jad0s/libretalk/internal/types"
+)
+
+var (
+	messagesPurged = metrics.NewCounterVec("libretalk_retention_purged_messages_total",
+		"Messages removed by the retention job, by content type.", "type")
+	filesPurged = metrics.NewCounter("libretalk_retention_purged_files_total",
+		"Uploads removed by the retention job.")
+	messagesExpired = metrics.NewCounter("libretalk_expired_messages_total",
+		"Messages removed because their disappearing timer ran out.")
+)
+
+// Job purges expired messages and the uploads only they referred to.
+type Job struct {
+	messages  store.MessageStore
+	policy    store.Retention
+	batchSize int
+	uploadDir string
+}
+
+// New builds a Job from the retention config.
+func New(messages store.MessageStore, cfg config.Retention, uploadDir string) *Job {
+	policy := store.Retention{
+		MaxAge:       cfg.MaxAge.Duration,
+		Delivered:    cfg.DeliveredMaxAge.Duration,
+		Undelivered:  cfg.UndeliveredMaxAge.Duration,
+		ContentTypes: make(map[string]time.Duration),
+	}
+	for ct, d := range cfg.ContentTypes {
+		policy.ContentTypes[ct] = d.Duration
+	}
+	return &Job{messages: messages, policy: policy, batchSize: cfg.BatchSize, uploadDir: uploadDir}
+}
+
+// Run purges once right away and then every interval until ctx is done.
+func (j *Job) Run(ctx context.Context, interval time.Duration) {
+	every(ctx, interval, "retention", j.Purge)
+}
+
+// Purge deletes batches until nothing expired is left, logging the
+// total. Each batch is its own transaction, so a failure keeps what the
+// earlier batches removed.
+func (j *Job) Purge(ctx context.Context) error {
+	if j.policy.IsZero() {
+		return nil
+	}
+	start := time.Now()
+	total := store.Purged{ByType: make(map[string]int)}
+	defer func() {
+		if len(total.Messages) == 0 {
+			slog.Debug("retention: nothing to purge")
+			return
+		}
+		slog.Info("retention: purged", "messages", len(total.Messages), "byType", total.ByType,
+			"files", len(total.Files), "conversations", total.Conversations,
+			"took", time.Since(start).Round(time.Millisecond))
+	}()
+
+	return batches(ctx, j.batchSize, func() (store.Purged, error) {
+		p, err := j.messages.PurgeMessages(j.policy, time.Now(), j.batchSize)
+		if err != nil {
+			return p, fmt.Errorf("purge: %w", err)
+		}
+		total.Messages = append(total.Messages, p.Messages...)
+		total.Conversations += p.Conversations
+		for ct, n := range p.ByType {
+			total.ByType[ct] += n
+			messagesPurged.Add(ct, float64(n))
+		}
+		removed := removeUploads(j.uploadDir, p.Files)
+		total.Files = append(total.Files, removed...)
+		filesPurged.Add("", float64(len(removed)))
+		return p, nil
+	})
+}
+
+// Expirer deletes messages whose disappearing timer ran out and hands
+// each batch to notify, so connected clients can drop them as well.
+type Expirer struct {
+	messages  store.MessageStore
+	batchSize int
+	uploadDir string
+	notify    func([]types.MessageRow)
+}
+
+// NewExpirer returns an Expirer removing up to batchSize messages per
+// transaction.
+func NewExpirer(messages store.MessageStore, batchSize int, uploadDir string, notify func([]types.MessageRow)) *Expirer {
+	return &Expirer{messages: messages, batchSize: batchSize, uploadDir: uploadDir, notify: notify}
+}
+
+// Run sweeps once right away and then every interval until ctx is done.
+func (e *Expirer) Run(ctx context.Context, interval time.Duration) {
+	every(ctx, interval, "expire messages", e.Sweep)
+}
+
+// Sweep removes every message that has expired by now.
+func (e *Expirer) Sweep(ctx context.Context) error {
+	return batches(ctx, e.batchSize, func() (store.Purged, error) {
+		p, err := e.messages.PurgeExpired(time.Now(), e.batchSize)
+		if err != nil {
+			return p, fmt.Errorf("expire: %w", err)
+		}
+		if len(p.Messages) == 0 {
+			return p, nil
+		}
+		removeUploads(e.uploadDir, p.Files)
+		messagesExpired.Add("", float64(len(p.Messages)))
+		slog.Debug("expired messages", "messages", len(p.Messages), "files", len(p.Files))
+		e.notify(p.Messages)
+		return p, nil
+	})
+}
+
+// every calls fn right away and then every interval until ctx is done,
+// logging its errors under name.
+func every(ctx context.Context, interval time.Duration, name string, fn func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := fn(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error(name, "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// batches calls batch until it comes back with fewer than size messages.
+func batches(ctx context.Context, size int, batch func() (store.Purged, error)) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p, err := batch()
+		if err != nil {
+			return err
+		}
+		if len(p.Messages) < size {
+			return nil
+		}
+	}
+}
+
+// removeUploads deletes the named files from the upload directory and
+// returns the ones that are gone now.
+func removeUploads(dir string, names []string) []string {
+	var removed []string
+	for _, name := range names {
+		err := os.Remove(filepath.Join(dir, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("remove upload", "file", name, "err", err)
+			continue
+		}
+		removed = append(removed, name)
+	}
+	return removed
+}
@@ -0,0 +1,114 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Reloader serves a certificate/key pair from disk and swaps it for the
+// new one whenever the files change or the process receives SIGHUP.
+// Handshakes already done keep their certificate, so renewals never
+// drop live connections.
+type Reloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time // newest mtime of the two files at last load
+}
+
+// New loads the pair once and returns a Reloader serving it.
+func New(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the pair from disk. On error the previous certificate stays in use.
+func (r *Reloader) Reload() error {
+	mod, err := r.newestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = mod
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch reloads the pair on SIGHUP and whenever either file's mtime moves,
+// checked every interval, until ctx is done. Any change counts, not just a
+// newer one: a pair restored from a backup or copied with its times kept
+// can be older than the one it replaces.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reloadAndLog("SIGHUP")
+		case <-ticker.C:
+			mod, err := r.newestModTime()
+			if err != nil {
+				slog.Warn("tls: stat certificate", "err", err)
+				continue
+			}
+			r.mu.RLock()
+			changed := !mod.Equal(r.modTime)
+			r.mu.RUnlock()
+			if changed {
+				r.reloadAndLog("file change")
+			}
+		}
+	}
+}
+
+func (r *Reloader) reloadAndLog(reason string) {
+	if err := r.Reload(); err != nil {
+		slog.Error("tls: reload failed, keeping old certificate", "reason", reason, "err", err)
+		return
+	}
+	slog.Info("tls: certificate reloaded", "reason", reason)
+}
+
+func (r *Reloader) newestModTime() (time.Time, error) {
+	var newest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("stat %s: %w", f, err)
+		}
+		if fi.ModTime().After(newest) {
+			newest = fi.ModTime()
+		}
+	}
+	return newest, nil
+}
@@ -0,0 +1,98 @@
+package tlscert
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePair writes a fresh self-signed pair for cn and sets both files'
+// mtime to mod. It returns the DER of the certificate.
+func writePair(t *testing.T, certFile, keyFile, cn string, mod time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return der
+}
+
+func leaf(t *testing.T, r *Reloader) []byte {
+	t.Helper()
+	c, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Certificate[0]
+}
+
+func TestWatchPicksUpRewrittenPair(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	first := writePair(t, certFile, keyFile, "first", start)
+
+	r, err := New(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(leaf(t, r), first) {
+		t.Fatal("New serves the wrong certificate")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	waitFor := func(want []byte, what string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !bytes.Equal(leaf(t, r), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("still serving the old certificate after %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	second := writePair(t, certFile, keyFile, "second", start.Add(time.Minute))
+	waitFor(second, "a newer pair")
+	// a pair older than the one it replaces, as restored from a backup
+	third := writePair(t, certFile, keyFile, "third", start.Add(-time.Minute))
+	waitFor(third, "an older pair")
+}
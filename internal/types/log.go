@@ -0,0 +1,80 @@
+package types
+
+import "log/slog"
+
+// The LogValue methods below decide what a frame looks like in the logs.
+// Passwords and tokens are left out entirely, and message bodies are
+// reduced to their length.
+
+func (r ActionRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("action", r.Action),
+		slog.String("username", r.Username),
+		slog.String("deviceId", r.DeviceID),
+	)
+}
+
+func (m IncomingMessage) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("from", m.From),
+		slog.String("to", m.To),
+		slog.String("contentType", m.ContentType),
+		slog.Int("contentLen", len(m.Content)),
+		slog.String("clientMsgId", m.ClientMsgID),
+	)
+}
+
+func (r HistoryRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("chatWith", r.ChatWith),
+		slog.Int("limit", r.Limit),
+		slog.Int64("before", r.Before),
+		slog.Int64("after", r.After),
+	)
+}
+
+func (r ChatsRequest) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("type", r.Type))
+}
+
+func (r SearchRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("queryLen", len(r.Query)),
+		slog.String("chatWith", r.ChatWith),
+		slog.String("from", r.From),
+		slog.String("contentType", r.ContentType),
+		slog.Int64("before", r.Before),
+	)
+}
+
+func (r EditRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int64("id", r.ID),
+		slog.Int("contentLen", len(r.Content)),
+	)
+}
+
+func (r DeleteRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int64("id", r.ID),
+		slog.String("scope", r.Scope),
+	)
+}
+
+func (r TimerRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("chatWith", r.ChatWith),
+		slog.Int64("seconds", r.Seconds),
+	)
+}
+
+func (r MarkReadRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("chatWith", r.ChatWith),
+		slog.Int64("upTo", r.UpTo),
+	)
+}
+
+func (r AckRequest) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int("ids", len(r.IDs)))
+}
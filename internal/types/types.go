@@ -7,12 +7,15 @@ import (
 )
 
 type IncomingMessage struct {
-	Type        string `json:"type"`
-	From        string `json:"from"`
-	To          string `json:"to"`
-	ContentType string `json:"contentType"`
-	Content     string `json:"content"`
-	Token       string `json:"token"`
+	Type        string     `json:"type"`
+	ID          int64      `json:"id,omitempty"` // set by the server; recipients ack it
+	From        string     `json:"from"`
+	To          string     `json:"to"`
+	ContentType string     `json:"contentType"`
+	Content     string     `json:"content"`
+	SentAt      *time.Time `json:"sentAt,omitempty"`      // set by the server
+	ClientMsgID string     `json:"clientMsgId,omitempty"` // sender's own ID; retries reuse it
+	Token       string     `json:"token,omitempty"`
 }
 
 type ActionRequest struct {
@@ -20,6 +23,7 @@ type ActionRequest struct {
 	Action   string `json:"action"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	DeviceID string `json:"deviceId,omitempty"` // login only: keeps a sync cursor per device
 }
 
 type HistoryRequest struct {
@@ -27,21 +31,35 @@ type HistoryRequest struct {
 	ChatWith string `json:"chatWith"`
 	Token    string `json:"token"`
 	Limit    int    `json:"limit"`
+	Before   int64  `json:"before,omitempty"` // only messages with a lower ID
+	After    int64  `json:"after,omitempty"`  // only messages with a higher ID
+}
+
+// HistoryPage answers a history request in a single frame.
+type HistoryPage struct {
+	Type     string       `json:"type"` // "historyPage"
+	ChatWith string       `json:"chatWith"`
+	Messages []MessageRow `json:"messages"`
+	HasMore  bool         `json:"hasMore"`
 }
 
 type MessageRow struct {
-	ID          int64
-	Sender      string
-	Recipient   string
-	ContentType string
-	Content     string
-	SentAt      time.Time
+	ID          int64      `json:"id"`
+	Sender      string     `json:"from"`
+	Recipient   string     `json:"to"`
+	ContentType string     `json:"contentType"`
+	Content     string     `json:"content"`
+	SentAt      time.Time  `json:"sentAt"`
+	EditedAt    *time.Time `json:"editedAt,omitempty"`  // set once the sender has edited it
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"` // set on tombstones; Content is then empty
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"` // set while the conversation has a disappearing timer
 }
 
 type ConnectionInfo struct {
 	Conn        *websocket.Conn // the WebSocket itself
-	IP          string          // conn.RemoteAddr().String()
+	IP          string          // client address, resolved through trusted proxies
 	ConnectedAt time.Time       // when this connection was opened
+	Device      string          // device ID given at login, if any
 }
 
 type ChatsRequest struct {
@@ -53,4 +71,127 @@ type Chat struct {
 	With            string    `json:"with"`            // the *other* user
 	LastMessage     string    `json:"lastMessage"`     // the snippet
 	LastMessageTime time.Time `json:"lastMessageTime"` // sortable timestamp
+	DisappearAfter  int64     `json:"disappearAfter"`  // disappearing timer in seconds, 0 = off
+	UnreadCount     int       `json:"unreadCount"`     // messages from With above the read cursor
+}
+
+type FileRow struct {
+	ID           string
+	Uploader     string
+	OriginalName string
+	ContentType  string
+	SizeBytes    int64
+	UploadedAt   time.Time
+}
+
+// SearchRequest looks through the caller's messages. All filters are
+// optional and combine with AND.
+type SearchRequest struct {
+	Type        string    `json:"type"`
+	Token       string    `json:"token"`
+	Query       string    `json:"query"`
+	ChatWith    string    `json:"chatWith,omitempty"`
+	From        string    `json:"from,omitempty"`
+	Since       time.Time `json:"since,omitempty"`
+	Until       time.Time `json:"until,omitempty"`
+	ContentType string    `json:"contentType,omitempty"` // "text" or "file"
+	Before      int64     `json:"before,omitempty"`      // continue below this message ID
+	Limit       int       `json:"limit,omitempty"`
+}
+
+// SearchResults answers a search, newest match first.
+type SearchResults struct {
+	Type     string       `json:"type"` // "searchResults"
+	Messages []MessageRow `json:"messages"`
+	HasMore  bool         `json:"hasMore"`
+}
+
+// EditRequest replaces the content of one of the caller's messages.
+type EditRequest struct {
+	Type    string `json:"type"`
+	Token   string `json:"token"`
+	ID      int64  `json:"id"`
+	Content string `json:"content"`
+}
+
+// MessageEdited tells both participants that a message changed.
+type MessageEdited struct {
+	Type     string    `json:"type"` // "edited"
+	ID       int64     `json:"id"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Content  string    `json:"content"`
+	EditedAt time.Time `json:"editedAt"`
+}
+
+// DeleteRequest removes a message. Scope "me" hides it from the caller
+// only; "everyone" (sender only) replaces it with a tombstone for both.
+type DeleteRequest struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+	ID    int64  `json:"id"`
+	Scope string `json:"scope"`
+}
+
+// MessageDeleted tells clients a message is gone. For scope "me" it only
+// goes to the caller's own connections.
+type MessageDeleted struct {
+	Type      string    `json:"type"` // "deleted"
+	ID        int64     `json:"id"`
+	Scope     string    `json:"scope"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// TimerRequest sets the disappearing timer of the conversation with
+// ChatWith. Seconds 0 turns it off.
+type TimerRequest struct {
+	Type     string `json:"type"`
+	Token    string `json:"token"`
+	ChatWith string `json:"chatWith"`
+	Seconds  int64  `json:"seconds"`
+}
+
+// MessagesExpired tells a participant that messages of the conversation
+// with ChatWith ran out their disappearing timer and are gone.
+type MessagesExpired struct {
+	Type     string  `json:"type"` // "expired"
+	ChatWith string  `json:"chatWith"`
+	IDs      []int64 `json:"ids"`
+}
+
+// MarkReadRequest moves the caller's read cursor in the conversation with
+// ChatWith up to message UpTo, or to the newest message when UpTo is 0.
+type MarkReadRequest struct {
+	Type     string `json:"type"`
+	Token    string `json:"token"`
+	ChatWith string `json:"chatWith"`
+	UpTo     int64  `json:"upTo,omitempty"`
+}
+
+// MessageSent tells the sender which ID and time the server gave their
+// message; a retry gets the same answer as the first attempt.
+type MessageSent struct {
+	Type        string    `json:"type"` // "sent"
+	ID          int64     `json:"id"`
+	ClientMsgID string    `json:"clientMsgId,omitempty"`
+	SentAt      time.Time `json:"sentAt"`
+}
+
+// ReadReceipt tells a sender that By has read their messages up to and
+// including UpTo.
+type ReadReceipt struct {
+	Type   string    `json:"type"` // "read"
+	By     string    `json:"by"`
+	UpTo   int64     `json:"upTo"`
+	ReadAt time.Time `json:"readAt"`
+}
+
+// AckRequest confirms that the caller's device has received the messages
+// with these IDs. Until then they are replayed on every login.
+type AckRequest struct {
+	Type  string  `json:"type"`
+	Token string  `json:"token"`
+	IDs   []int64 `json:"ids"`
 }